@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// 票据作废时拒绝的贷款原因
+const AbolishRejectReason = "the bill is abolished"
+
+// 未结束的贷款申请状态：等待担保、担保方已担保、等待金融机构审批
+var openLoanStates = map[string]bool{
+	LoanGurantee: true,
+	Endorsed:     true,
+	LoanApplied:  true,
+}
+
+// 取得票据上未结束的贷款申请
+func openLoansOfBill(stub shim.ChaincodeStubInterface, billID string) ([]*Loan, error) {
+	ids, err := queryAllIndexIDs(stub, "bill~loan", []string{billID})
+	if err != nil {
+		return nil, err
+	}
+
+	repo := NewLoanRepo(stub)
+	loans := make([]*Loan, 0)
+	for _, id := range ids {
+		loan, err := repo.Get(id)
+		if err != nil {
+			return nil, err
+		}
+
+		// 索引项在记录修改时同步更新，这里再确认一次票据号
+		if loan.BillID == billID && openLoanStates[loan.State] {
+			loans = append(loans, loan)
+		}
+	}
+
+	return loans, nil
+}
+
+// 作废票据：拒绝票据上未结束的贷款申请，票据状态改为abolished
+func abolishBillObj(stub shim.ChaincodeStubInterface, bill *Bill) ([]*Loan, error) {
+	loans, err := openLoansOfBill(stub, bill.BillID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, loan := range loans {
+		loan.RefuseReason = AbolishRejectReason
+		err = setLoanStateThenPut(stub, loan, loan.State, Rejected)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = closeLoanOffers(stub, loan.LoanID, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bill.State = BillAbolished
+	err = NewBillRepo(stub).Put(bill)
+	if err != nil {
+		return nil, err
+	}
+
+	return loans, nil
+}
+
+//countersignAbolish 还款人会签作废流转过的票据，票据上未结束的贷款申请一并拒绝
+//  args: 0 - Bill_No ; 1 - Drawee Name
+func (sfb *SupplyFinance) countersignAbolish(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	bill, err := NewBillRepo(stub).Get(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if !bill.ValidateDraweeName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke countersignAbolish failed: countersigner is not same with current drawee")
+	}
+
+	if !bill.ValidateState(BillAbolishing) {
+		return nil, errWrongState("bill", bill.State, BillAbolishing)
+	}
+
+	loans, err := abolishBillObj(stub, bill)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"bill": bill, "rejected_loans": loans}, nil
+}
+
+//rejectAbolish 还款人拒绝作废流转过的票据，票据恢复为申请作废前的状态
+//  args: 0 - Bill_No ; 1 - Drawee Name
+func (sfb *SupplyFinance) rejectAbolish(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	bill, err := NewBillRepo(stub).Get(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if !bill.ValidateDraweeName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke rejectAbolish failed: drawee is not same with current drawee")
+	}
+
+	loans, err := openLoansOfBill(stub, bill.BillID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 有未结束的贷款申请时票据仍处于申请抵押状态
+	restore := Endorsed
+	if len(loans) > 0 {
+		restore = BillLoanReady
+	}
+
+	err = setBillStateThenPut(stub, bill, BillAbolishing, restore)
+	if err != nil {
+		return nil, err
+	}
+
+	return bill, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+)
+
+// 作废票据的返回结果
+type abolishResult struct {
+	Bill          Bill   `json:"bill"`
+	RejectedLoans []Loan `json:"rejected_loans"`
+}
+
+func TestAbolishRejectsOpenLoans(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.applyLoan("l1", "b1", "owner", 800, ""))
+
+	mustFail(t, s.invoke("abolishBill", "b1", "payeen"), ErrForbidden)
+
+	var res abolishResult
+	decodeData(t, mustOK(t, s.invoke("abolishBill", "b1", "ownern")), &res)
+	if res.Bill.State != BillAbolished || len(res.RejectedLoans) != 1 || res.RejectedLoans[0].RefuseReason != AbolishRejectReason {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	var loan Loan
+	s.getRecord(loanTable, "l1", &loan)
+	if loan.State != Rejected {
+		t.Fatalf("unexpected loan state: %s", loan.State)
+	}
+}
+
+func TestAbolishTransferredBillNeedsCountersign(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.invoke("transferBill", transferArg("b1", "owner", "payee", 0)))
+	mustOK(t, s.invoke("acceptBillTransfer", "b1", "payeen"))
+	mustOK(t, s.applyLoan("l1", "b1", "payee", 800, ""))
+
+	// 流转过的票据作废需还款人会签
+	var res abolishResult
+	ret := mustOK(t, s.invoke("abolishBill", "b1", "payeen"))
+	decodeData(t, ret, &res)
+	if res.Bill.State != BillAbolishing || ret.Description != "invoke abolishBill success, waiting for the drawee's countersign" {
+		t.Fatalf("unexpected result: %+v", ret)
+	}
+
+	// 还款人拒绝后恢复为申请抵押状态
+	mustFail(t, s.invoke("rejectAbolish", "b1", "payeen"), ErrForbidden)
+	var bill Bill
+	decodeData(t, mustOK(t, s.invoke("rejectAbolish", "b1", "draween")), &bill)
+	if bill.State != BillLoanReady {
+		t.Fatalf("unexpected bill state: %s", bill.State)
+	}
+
+	mustFail(t, s.invoke("countersignAbolish", "b1", "draween"), ErrWrongState)
+	mustOK(t, s.invoke("abolishBill", "b1", "payeen"))
+	mustFail(t, s.invoke("countersignAbolish", "b1", "payeen"), ErrForbidden)
+
+	decodeData(t, mustOK(t, s.invoke("countersignAbolish", "b1", "draween")), &res)
+	if res.Bill.State != BillAbolished || len(res.RejectedLoans) != 1 || res.RejectedLoans[0].LoanID != "l1" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
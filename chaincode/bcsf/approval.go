@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var approvalPolicyTable = tableDef{"approval_policy", "APPL_", nil}
+
+var approvalTable = tableDef{"approval", "APRV_", approvalIndexes}
+
+var approvalIndexes = []indexDef{
+	{"participant~approval", "apv_participant"}, // 参与方 -> 审批
+}
+
+func init() {
+	registerTables(approvalPolicyTable, approvalTable)
+}
+
+// 审批对象
+const (
+	ApproveContract = "contract" // 担保合同
+	ApproveBill     = "bill"     // 担保票据
+)
+
+// 审批状态
+const (
+	ApprovalPending  = "pending"  // 签名数未达到门限
+	ApprovalApproved = "approved" // 签名数达到门限，审批对象已担保
+)
+
+//Approver 审批人，以调用者证书身份识别
+type Approver struct {
+	ID    string `json:"approver_id"`   //证书身份，即cid.GetID的返回值
+	MSPID string `json:"approver_msp"`  //证书所属MSP ID
+	Name  string `json:"approver_name"` //审批人名称，如经办人、财务经理
+}
+
+//ApprovalPolicy 参与方的N-of-M审批策略，担保合同或票据须有Threshold个审批人签名
+type ApprovalPolicy struct {
+	Participant string     `json:"ap_participant" sf:"required"` //参与方系统账号
+	Threshold   int        `json:"ap_threshold" sf:"gt=0"`       //门限N，须签名的审批人数量
+	Approvers   []Approver `json:"ap_approvers" sf:"required"`   //审批人M
+	UpdateDate  int64      `json:"ap_update_date" sf:"readonly"` //更新时间
+}
+
+func (ap ApprovalPolicy) recordID() string {
+	return ap.Participant
+}
+
+func (ap ApprovalPolicy) validate() error {
+	if ap.Threshold < 1 || ap.Threshold > len(ap.Approvers) {
+		return newError(ErrInvalidArg, "the threshold of approval policy should be between 1 and the count of approvers").With("ap_threshold", ap.Threshold)
+	}
+
+	return nil
+}
+
+// 按证书身份查找审批人
+func (ap ApprovalPolicy) approver(id, mspID string) *Approver {
+	for i := range ap.Approvers {
+		if ap.Approvers[i].ID == id && ap.Approvers[i].MSPID == mspID {
+			return &ap.Approvers[i]
+		}
+	}
+
+	return nil
+}
+
+//ApprovalPolicyRepo 审批策略表
+type ApprovalPolicyRepo struct {
+	Store
+}
+
+func NewApprovalPolicyRepo(stub shim.ChaincodeStubInterface) ApprovalPolicyRepo {
+	return ApprovalPolicyRepo{newStore(stub, approvalPolicyTable)}
+}
+
+func (r ApprovalPolicyRepo) Find(participant string) (*ApprovalPolicy, error) {
+	var ap ApprovalPolicy
+	found, err := r.Store.Find(participant, &ap)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &ap, nil
+}
+
+func (r ApprovalPolicyRepo) Put(ap *ApprovalPolicy) error {
+	return r.Store.Put(ap.Participant, *ap)
+}
+
+//Signature 审批人的签名
+type Signature struct {
+	ApproverID   string `json:"approver_id"`   //证书身份
+	MSPID        string `json:"approver_msp"`  //证书所属MSP ID
+	ApproverName string `json:"approver_name"` //审批人名称
+	TxID         string `json:"tx_id"`         //签名的交易ID
+	SignDate     int64  `json:"sign_date"`     //签名时间
+}
+
+//Approval 担保合同或票据的审批记录，同一合同的每个版本分别审批
+type Approval struct {
+	Target      string      `json:"apv_target"`      //审批对象：contract或bill
+	TargetID    string      `json:"apv_target_id"`   //合同编号或票据编号
+	Version     int32       `json:"apv_version"`     //合同版本，票据为0
+	Participant string      `json:"apv_participant"` //审批的参与方系统账号，即还款人
+	Threshold   int         `json:"apv_threshold"`   //门限，取签名时的审批策略
+	Signatures  []Signature `json:"signatures"`      //已签名的审批人
+	State       string      `json:"apv_state"`       //审批状态
+}
+
+// 审批ID：审批对象_编号_版本
+func approvalID(target, targetID string, version int32) string {
+	return fmt.Sprintf("%s_%s_%d", target, targetID, version)
+}
+
+func (apv Approval) recordID() string {
+	return approvalID(apv.Target, apv.TargetID, apv.Version)
+}
+
+func (apv Approval) validate() error {
+	if apv.State == "" {
+		return newError(ErrInvalidArg, "the state of approval should not be empty").With("id", apv.recordID())
+	}
+
+	return nil
+}
+
+// 审批人是否已签名
+func (apv Approval) signedBy(id, mspID string) bool {
+	for _, s := range apv.Signatures {
+		if s.ApproverID == id && s.MSPID == mspID {
+			return true
+		}
+	}
+
+	return false
+}
+
+//ApprovalRepo 审批表
+type ApprovalRepo struct {
+	Store
+}
+
+func NewApprovalRepo(stub shim.ChaincodeStubInterface) ApprovalRepo {
+	return ApprovalRepo{newStore(stub, approvalTable)}
+}
+
+func (r ApprovalRepo) Find(target, targetID string, version int32) (*Approval, error) {
+	var apv Approval
+	found, err := r.Store.Find(approvalID(target, targetID, version), &apv)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &apv, nil
+}
+
+func (r ApprovalRepo) Put(apv *Approval) error {
+	return r.Store.Put(apv.recordID(), *apv)
+}
+
+// 记录调用者对审批对象的签名，返回审批记录及签名数是否已达到门限；参与方没有审批策略时单签即可，不生成审批记录
+func signApproval(stub shim.ChaincodeStubInterface, target, targetID string, version int32, participant string) (*Approval, bool, error) {
+	policy, err := NewApprovalPolicyRepo(stub).Find(participant)
+	if err != nil || policy == nil {
+		return nil, err == nil, err
+	}
+
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return nil, false, wrapError(err)
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, false, wrapError(err)
+	}
+
+	approver := policy.approver(id, mspID)
+	if approver == nil {
+		return nil, false, errForbidden("the caller is not an approver of the participant: %s", participant).With("participant", participant).With("approver_msp", mspID)
+	}
+
+	repo := NewApprovalRepo(stub)
+	apv, err := repo.Find(target, targetID, version)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if apv == nil {
+		apv = &Approval{Target: target, TargetID: targetID, Version: version, Participant: participant, Signatures: []Signature{}}
+	}
+
+	if apv.signedBy(id, mspID) {
+		return nil, false, newError(ErrDuplicate, "the approver has signed the %s: %s", target, targetID).With("approver_name", approver.Name)
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return nil, false, err
+	}
+
+	apv.Signatures = append(apv.Signatures, Signature{
+		ApproverID:   id,
+		MSPID:        mspID,
+		ApproverName: approver.Name,
+		TxID:         stub.GetTxID(),
+		SignDate:     now,
+	})
+	apv.Threshold = policy.Threshold
+	apv.State = ApprovalPending
+	if len(apv.Signatures) >= apv.Threshold {
+		apv.State = ApprovalApproved
+	}
+
+	err = repo.Put(apv)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return apv, apv.State == ApprovalApproved, nil
+}
+
+//setApprovalPolicy 设置参与方的审批策略，首次设置须由参与方登记的组织或管理员设置，已有策略时须由现有审批人修改
+//  args: 0 - {Approval Policy Object}
+func (sfb *SupplyFinance) setApprovalPolicy(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	ap := *args.Object(0).(*ApprovalPolicy)
+
+	err := ap.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	repo := NewApprovalPolicyRepo(stub)
+	old, err := repo.Find(ap.Participant)
+	if err != nil {
+		return nil, err
+	}
+
+	// 防止他人降低已有策略的门限
+	if old != nil {
+		id, err := cid.GetID(stub)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		if old.approver(id, mspID) == nil {
+			return nil, errForbidden("Chaincode Invoke setApprovalPolicy failed: the caller is not an approver of the current policy").With("participant", ap.Participant)
+		}
+	} else {
+		// 防止他人抢先为参与方设置策略
+		err = requireParticipantOrAdmin(stub, ap.Participant)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ap.UpdateDate, err = getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.Put(&ap)
+	if err != nil {
+		return nil, err
+	}
+
+	return ap, nil
+}
+
+// 审批对象是否仍在等待担保，合同被修改或拒绝、票据被拒绝后原审批记录不再有效
+func approvalAwaiting(stub shim.ChaincodeStubInterface, apv *Approval) (bool, error) {
+	switch apv.Target {
+	case ApproveContract:
+		ct, err := NewContractRepo(stub).Find(apv.TargetID)
+		if err != nil || ct == nil {
+			return false, err
+		}
+
+		return ct.State == ContractUploaded && ct.currentVersion() == apv.Version, nil
+	case ApproveBill:
+		bill, err := NewBillRepo(stub).Find(apv.TargetID)
+		if err != nil || bill == nil {
+			return false, err
+		}
+
+		return bill.State == BillIssued, nil
+	}
+
+	return false, nil
+}
+
+//queryPendingApprovals 查询参与方签名数未达到门限、仍在等待担保的审批
+//  args: 0 - Participant
+func (sfb *SupplyFinance) queryPendingApprovals(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	ids, err := queryAllIndexIDs(stub, "participant~approval", []string{args.String(0)})
+	if err != nil {
+		return nil, err
+	}
+
+	store := newStore(stub, approvalTable)
+	approvals := make([]*Approval, 0, len(ids))
+	for _, id := range ids {
+		var apv Approval
+		if err := store.Get(id, &apv); err != nil {
+			return nil, err
+		}
+
+		if apv.State != ApprovalPending {
+			continue
+		}
+
+		awaiting, err := approvalAwaiting(stub, &apv)
+		if err != nil {
+			return nil, err
+		}
+
+		if awaiting {
+			approvals = append(approvals, &apv)
+		}
+	}
+
+	return describe("invoke queryPendingApprovals success", approvals), nil
+}
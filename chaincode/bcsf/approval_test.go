@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+)
+
+// 审批人，approver_id取证书身份，不改变当前调用者
+func (s *testStub) approver(id *testIdentity, name string) string {
+	creator := s.creator
+	defer func() { s.creator = creator }()
+
+	s.as(id)
+	certID, err := cid.GetID(s)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	return fmt.Sprintf(`{"approver_id":"%s","approver_msp":"%s","approver_name":"%s"}`, certID, id.mspID, name)
+}
+
+// 审批策略参数
+func approvalPolicy(participant string, threshold int, approvers ...string) string {
+	list := ""
+	for i, a := range approvers {
+		if i > 0 {
+			list += ","
+		}
+		list += a
+	}
+
+	return fmt.Sprintf(`{"ap_participant":"%s","ap_threshold":%d,"ap_approvers":[%s]}`, participant, threshold, list)
+}
+
+func TestSetApprovalPolicyAuthorization(t *testing.T) {
+	s := newTestStub(t)
+	admin := newIdentity(t, testAdminMSP, "admin", "")
+	op := newIdentity(t, "DraweeMSP", "op", "")
+	fm := newIdentity(t, "DraweeMSP", "fm", "")
+	other := newIdentity(t, "OtherMSP", "other", "")
+
+	s.register("drawee", op)
+	policy := approvalPolicy("drawee", 1, s.approver(op, "经办人"))
+
+	// 首次设置须由参与方登记的组织或管理员设置
+	mustFail(t, s.as(other).invoke("setApprovalPolicy", policy), ErrForbidden)
+	mustFail(t, s.as(op).invoke("setApprovalPolicy", approvalPolicy("unknown", 1, s.approver(op, "经办人"))), ErrForbidden)
+	mustOK(t, s.as(admin).invoke("setApprovalPolicy", approvalPolicy("unknown", 1, s.approver(op, "经办人"))))
+	mustOK(t, s.as(fm).invoke("setApprovalPolicy", policy))
+
+	// 已有策略时须由现有审批人修改，同组织的其他人也不能修改
+	policy = approvalPolicy("drawee", 2, s.approver(op, "经办人"), s.approver(fm, "财务经理"))
+	mustFail(t, s.as(fm).invoke("setApprovalPolicy", policy), ErrForbidden)
+	mustFail(t, s.as(admin).invoke("setApprovalPolicy", policy), ErrForbidden)
+	mustOK(t, s.as(op).invoke("setApprovalPolicy", policy))
+
+	// 达到门限才担保
+	mustOK(t, s.as(other).invoke("issueBill", toJSON(t, testBill("b1", "drawee", "owner", 1000))))
+	mustFail(t, s.as(other).invoke("endorseBill", "b1", "draween"), ErrForbidden)
+	mustOK(t, s.as(op).invoke("endorseBill", "b1", "draween"))
+
+	var bill Bill
+	s.getRecord(billTable, "b1", &bill)
+	if bill.State == Endorsed {
+		t.Fatal("the bill should wait for other approvals")
+	}
+
+	mustOK(t, s.as(fm).invoke("endorseBill", "b1", "draween"))
+	s.getRecord(billTable, "b1", &bill)
+	if bill.State != Endorsed {
+		t.Fatalf("unexpected bill state: %s", bill.State)
+	}
+}
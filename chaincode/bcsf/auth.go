@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// 调用者证书中记录角色的属性名，由CA签发证书时写入
+const RoleAttr = "sf.role"
+
+// 角色
+const (
+	RoleMigration = "migration" // 数据迁移管理员，可以不经合同和还款人直接生成已背书的票据
+	RoleAdmin     = "admin"     // 链码管理员，调用者组织须在配置的admin_msps中，不取证书属性
+	RoleBank      = "bank"      // 金融机构，可以报价、审批贷款，操作的金融机构账号还须登记在调用者组织下
+)
+
+// 调用者证书是否带有指定角色
+func hasRole(stub shim.ChaincodeStubInterface, role string) (bool, error) {
+	v, found, err := cid.GetAttributeValue(stub, RoleAttr)
+	if err != nil {
+		return false, wrapError(err)
+	}
+
+	return found && v == role, nil
+}
+
+// 要求调用者带有指定角色
+func requireRole(stub shim.ChaincodeStubInterface, role string) error {
+	ok, err := hasRole(stub, role)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return errForbidden("the caller should have the role: %s", role).With("role", role)
+	}
+
+	return nil
+}
+
+// 要求调用者属于配置中的管理员组织
+func requireAdmin(stub shim.ChaincodeStubInterface, cfg *Config) error {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if !cfg.isAdmin(mspID) {
+		return errForbidden("the caller's organization is not an admin: %s", mspID).With("msp_id", mspID)
+	}
+
+	return nil
+}
+
+// 要求调用者属于参与方登记的组织(registerParticipant)，参与方未登记时同样返回FORBIDDEN
+func requireParticipant(stub shim.ChaincodeStubInterface, participant string) error {
+	pm, err := NewParticipantMSPRepo(stub).Find(participant)
+	if err != nil {
+		return err
+	}
+
+	if pm == nil {
+		return errForbidden("the participant is not registered: %s", participant).With("participant", participant)
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if mspID != pm.MSPID {
+		return errForbidden("the caller's organization is not the participant's: %s", mspID).With("msp_id", mspID).With("pm_msp", pm.MSPID)
+	}
+
+	return nil
+}
+
+// 要求调用者属于参与方登记的组织，或属于管理员组织
+func requireParticipantOrAdmin(stub shim.ChaincodeStubInterface, participant string) error {
+	cfg, err := loadConfig(stub)
+	if err != nil {
+		return err
+	}
+
+	if err = requireAdmin(stub, cfg); err == nil {
+		return nil
+	}
+
+	return requireParticipant(stub, participant)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// 单次批量操作最多的条目数
+const MaxBatchItems = 500
+
+//EndorseBillItem 批量担保票据的条目
+type EndorseBillItem struct {
+	BillID     string `json:"bill_id"`     //票据号
+	DraweeName string `json:"drawee_name"` //还款人名称
+}
+
+//EndorseBillsArg 批量担保票据参数
+type EndorseBillsArg struct {
+	Atomic bool              `json:"atomic"` //true时全部成功才生效，有失败时全部不生效
+	Items  []EndorseBillItem `json:"items"`  //票据列表
+}
+
+//TransferBillsArg 批量流转票据参数
+type TransferBillsArg struct {
+	Atomic bool              `json:"atomic"` //true时全部成功才生效，有失败时全部不生效
+	Items  []TransferInfoArg `json:"items"`  //流转信息列表
+}
+
+//IssueContractsArg 批量上传合同参数
+type IssueContractsArg struct {
+	Atomic bool       `json:"atomic"` //true时全部成功才生效，有失败时全部不生效
+	Items  []Contract `json:"items"`  //合同列表
+}
+
+//BatchItemResult 批量操作中一个条目的结果
+type BatchItemResult struct {
+	Index   int                    `json:"index"`              //条目在参数中的序号，从0开始
+	ID      string                 `json:"id"`                 //票据号或合同号
+	Success bool                   `json:"success"`            //是否成功
+	Data    interface{}            `json:"data,omitempty"`     //成功时返回受影响的记录
+	ErrCode string                 `json:"err_code,omitempty"` //失败时的错误码
+	Error   string                 `json:"error,omitempty"`    //失败时的错误描述
+	Details map[string]interface{} `json:"details,omitempty"`  //失败时的错误详细信息
+}
+
+//BatchReport 批量操作结果
+type BatchReport struct {
+	Atomic    bool              `json:"atomic"`    //是否原子执行
+	Total     int               `json:"total"`     //条目数
+	Succeeded int               `json:"succeeded"` //成功条目数
+	Failed    int               `json:"failed"`    //失败条目数
+	Items     []BatchItemResult `json:"items"`     //每个条目的结果
+}
+
+// 检查批量条目数
+func checkBatchSize(function string, n int) error {
+	if n == 0 || n > MaxBatchItems {
+		return newError(ErrInvalidArg, "Chaincode Invoke %s failed: the count of items should be between 1 and %d", function, MaxBatchItems).With("items", n)
+	}
+
+	return nil
+}
+
+// 逐条执行批量操作：每个条目在单独的缓冲中执行，成功时并入批量缓冲，失败时丢弃该条目的写入；
+// 原子执行时有条目失败则返回错误，全部不生效，否则把成功条目写入账本并返回每条结果
+func runBatch(stub shim.ChaincodeStubInterface, function string, atomic bool, ids []string, apply func(stub shim.ChaincodeStubInterface, i int) (interface{}, error)) (interface{}, error) {
+	batch := newBufferedStub(stub)
+	report := BatchReport{Atomic: atomic, Total: len(ids), Items: make([]BatchItemResult, 0, len(ids))}
+
+	for i, id := range ids {
+		item := newBufferedStub(batch)
+		result := BatchItemResult{Index: i, ID: id}
+
+		data, err := apply(item, i)
+		if err == nil {
+			err = item.commit()
+		}
+
+		if err != nil {
+			e := wrapError(err)
+			result.ErrCode, result.Error, result.Details = e.Code, e.Message, e.Details
+			report.Failed++
+		} else {
+			result.Success, result.Data = true, data
+			report.Succeeded++
+		}
+		report.Items = append(report.Items, result)
+	}
+
+	if atomic && report.Failed > 0 {
+		res := newError(ErrBatchFailed, "Chaincode Invoke %s failed: %d of %d items failed, nothing is applied", function, report.Failed, report.Total)
+		return nil, res.With("report", report)
+	}
+
+	err := batch.commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+//endorseBills 还款人批量担保票据，每条的规则同endorseBill
+//  args: 0 - {EndorseBillsArg Object}
+func (sfb *SupplyFinance) endorseBills(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	arg := *args.Object(0).(*EndorseBillsArg)
+
+	err := checkBatchSize("endorseBills", len(arg.Items))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(arg.Items))
+	for i, it := range arg.Items {
+		ids[i] = it.BillID
+	}
+
+	return runBatch(stub, "endorseBills", arg.Atomic, ids, func(stub shim.ChaincodeStubInterface, i int) (interface{}, error) {
+		bill, apv, err := endorseBillObj(stub, arg.Items[i].BillID, arg.Items[i].DraweeName)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{"bill": bill, "approval": apv}, nil
+	})
+}
+
+//transferBills 持票人批量流转票据，每条的规则同transferBill
+//  args: 0 - {TransferBillsArg Object}
+func (sfb *SupplyFinance) transferBills(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	arg := *args.Object(0).(*TransferBillsArg)
+
+	err := checkBatchSize("transferBills", len(arg.Items))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(arg.Items))
+	for i, it := range arg.Items {
+		ids[i] = it.BillID
+	}
+
+	return runBatch(stub, "transferBills", arg.Atomic, ids, func(stub shim.ChaincodeStubInterface, i int) (interface{}, error) {
+		ti := arg.Items[i]
+		if err := validateArg(&ti); err != nil {
+			return nil, err
+		}
+
+		bill, to, err := transferBillObj(stub, ti)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{"bill": bill, "offer": to}, nil
+	})
+}
+
+//issueContracts 批量上传合同，每条的规则同issueContract
+//  args: 0 - {IssueContractsArg Object}
+func (sfb *SupplyFinance) issueContracts(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	arg := *args.Object(0).(*IssueContractsArg)
+
+	err := checkBatchSize("issueContracts", len(arg.Items))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(arg.Items))
+	for i, it := range arg.Items {
+		ids[i] = it.ContractID
+	}
+
+	return runBatch(stub, "issueContracts", arg.Atomic, ids, func(stub shim.ChaincodeStubInterface, i int) (interface{}, error) {
+		ct := arg.Items[i]
+		if err := validateArg(&ct); err != nil {
+			return nil, err
+		}
+
+		err := sfb.issueContractObj(stub, &ct, ContractUploaded)
+		if err != nil {
+			return nil, err
+		}
+
+		return ct, nil
+	})
+}
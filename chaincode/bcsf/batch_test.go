@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 批量担保参数，票据的还款人均为drawee
+func endorseBillsArg(atomic bool, billIDs ...string) string {
+	arg := EndorseBillsArg{Atomic: atomic}
+	for _, id := range billIDs {
+		arg.Items = append(arg.Items, EndorseBillItem{BillID: id, DraweeName: "draween"})
+	}
+
+	b, _ := json.Marshal(arg)
+	return string(b)
+}
+
+func TestEndorseBillsAtomic(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "DraweeMSP", "drawee", ""))
+	mustOK(t, s.invoke("issueBill", toJSON(t, testBill("b1", "drawee", "owner", 100))))
+	mustOK(t, s.invoke("issueBill", toJSON(t, testBill("b2", "drawee", "owner", 100))))
+
+	// 原子执行时有条目失败，全部不生效
+	ret := mustFail(t, s.invoke("endorseBills", endorseBillsArg(true, "b1", "b3", "b2")), ErrBatchFailed)
+
+	var report BatchReport
+	decodeData(t, chaincodeRet{Data: ret.Details["report"]}, &report)
+	if report.Total != 3 || report.Succeeded != 2 || report.Failed != 1 || report.Items[1].ErrCode != ErrNotFound {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	for _, id := range []string{"b1", "b2"} {
+		var bill Bill
+		s.getRecord(billTable, id, &bill)
+		if bill.State != BillIssued {
+			t.Fatalf("bill %s should not be endorsed, state: %s", id, bill.State)
+		}
+	}
+}
+
+func TestEndorseBillsPerItem(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "DraweeMSP", "drawee", ""))
+	mustOK(t, s.invoke("issueBill", toJSON(t, testBill("b1", "drawee", "owner", 100))))
+	mustOK(t, s.invoke("issueBill", toJSON(t, testBill("b2", "drawee", "owner", 100))))
+
+	// 非原子执行时成功条目生效；后面的条目能看到前面条目的写入，重复担保返回WRONG_STATE
+	var report BatchReport
+	decodeData(t, mustOK(t, s.invoke("endorseBills", endorseBillsArg(false, "b1", "b3", "b1", "b2"))), &report)
+	if report.Succeeded != 2 || report.Failed != 2 || report.Items[1].ErrCode != ErrNotFound || report.Items[2].ErrCode != ErrWrongState {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	for _, id := range []string{"b1", "b2"} {
+		var bill Bill
+		s.getRecord(billTable, id, &bill)
+		if bill.State != Endorsed {
+			t.Fatalf("bill %s should be endorsed, state: %s", id, bill.State)
+		}
+	}
+
+	mustFail(t, s.invoke("endorseBills", endorseBillsArg(false)), ErrInvalidArg)
+}
+
+func TestBufferedStubMergesQueries(t *testing.T) {
+	s := newTestStub(t)
+	s.putRaw(billTable, "b1", testBill("b1", "drawee", "owner", 100))
+	s.putRaw(billTable, "b2", testBill("b2", "drawee", "owner", 100))
+
+	keys := func(it shim.StateQueryIteratorInterface, err error) []string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+
+		var keys []string
+		for it.HasNext() {
+			kv, err := it.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, kv.Key)
+		}
+		return keys
+	}
+
+	s.run(func(stub shim.ChaincodeStubInterface) pb.Response {
+		batch := newBufferedStub(stub)
+		item := newBufferedStub(batch)
+		item.PutState(billTable.Prefix+"b3", []byte("{}"))
+		item.DelState(billTable.Prefix + "b1")
+		idx, _ := item.CreateCompositeKey("owner~bill", []string{"owner", "b3"})
+		item.PutState(idx, []byte{0x00})
+
+		// 下层的删除和新增都能查到，复合键不出现在范围查询中
+		got := keys(item.GetStateByRange(billTable.Prefix, prefixEnd(billTable.Prefix)))
+		if fmt.Sprint(got) != fmt.Sprint([]string{billTable.Prefix + "b2", billTable.Prefix + "b3"}) {
+			t.Fatalf("unexpected range keys: %v", got)
+		}
+
+		// 条目提交到批量缓冲后，下一个条目能按索引查到
+		if err := item.commit(); err != nil {
+			t.Fatal(err)
+		}
+		ids, err := queryAllIndexIDs(newBufferedStub(batch), "owner~bill", []string{"owner"})
+		if err != nil || fmt.Sprint(ids) != "[b3]" {
+			t.Fatalf("unexpected index ids: %v, %v", ids, err)
+		}
+
+		// 提交前不影响下层stub
+		if got := keys(stub.GetStateByPartialCompositeKey("owner~bill", []string{"owner"})); len(got) != 0 {
+			t.Fatalf("unexpected keys before commit: %v", got)
+		}
+		return shim.Success(nil)
+	})
+}
+
+func TestBatchItemsOnSameBill(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "DraweeMSP", "drawee", ""))
+	s.issueEndorsedBill("b1", "drawee", "owner", 1000)
+
+	// 两个条目用同一票据申请贷款，第二个条目按bill~loan索引看到第一个条目的申请
+	var report BatchReport
+	res := s.run(func(stub shim.ChaincodeStubInterface) pb.Response {
+		data, err := runBatch(stub, "applyLoans", false, []string{"l1", "l2"}, func(stub shim.ChaincodeStubInterface, i int) (interface{}, error) {
+			var ln Loan
+			if err := json.Unmarshal([]byte(loanArg([]string{"l1", "l2"}[i], "b1", "owner", 500, "")), &ln); err != nil {
+				return nil, err
+			}
+			return tryPutApplyLoanObj(stub, ln, LoanApplied)
+		})
+		if err != nil {
+			return retError(err)
+		}
+		return retSuccess("ok", data)
+	})
+	decodeData(t, mustOK(t, res), &report)
+
+	if report.Succeeded != 1 || report.Items[1].ErrCode != ErrWrongState || report.Items[1].Details["loan_id"] != "l1" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
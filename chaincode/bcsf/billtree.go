@@ -0,0 +1,187 @@
+package main
+
+import (
+	"math"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// 金额比较的误差
+const AmountEpsilon = 0.000001
+
+func amountEqual(a, b float64) bool {
+	return math.Abs(a-b) < AmountEpsilon
+}
+
+//BillTreeNode 票据拆分树的节点
+type BillTreeNode struct {
+	BillID     string          `json:"bill_id"`               //票据号
+	Amount     float64         `json:"amount"`                //票据金额
+	AmountUnit string          `json:"amount_unit"`           //金额单位
+	Owner      string          `json:"owner"`                 //持票人系统账号
+	OwnerName  string          `json:"owner_name"`            //持票人名称
+	State      string          `json:"state"`                 //票据状态
+	SplitCount int32           `json:"split_count"`           //该票据通过几次拆分而生成
+	IsContract bool            `json:"contract,omitempty"`    //节点是否为原始合同
+	Childs     []*BillTreeNode `json:"child_bills,omitempty"` //拆分后的子票据
+}
+
+//BillTree 票据拆分树，从原始合同/票据到所有子票据
+type BillTree struct {
+	ContractID    string        `json:"contract_id,omitempty"` //生成原始票据的合同号
+	Root          *BillTreeNode `json:"root"`                  //原始合同或原始票据
+	RootAmount    float64       `json:"root_amount"`           //原始票据金额，根为合同时为合同生成的所有票据金额之和
+	LeafAmountSum float64       `json:"leaf_amount_sum"`       //所有叶子票据金额之和
+	Balanced      bool          `json:"balanced"`              //叶子票据金额之和是否等于原始票据金额
+}
+
+// 沿ParentID向上查找原始票据，ParentID不是票据时即为原始票据
+func findRootBill(repo BillRepo, bill *Bill) (*Bill, error) {
+	visited := map[string]bool{bill.BillID: true}
+
+	for bill.ParentID != "" {
+		parent, err := repo.Find(bill.ParentID)
+		if err != nil {
+			return nil, err
+		} else if parent == nil {
+			break
+		}
+
+		if visited[parent.BillID] {
+			return nil, errCorrupt(billTable.Name, parent.BillID, "circular parent of bill")
+		}
+		visited[parent.BillID] = true
+
+		bill = parent
+	}
+
+	return bill, nil
+}
+
+// 递归构造拆分子树，返回节点及叶子票据金额之和
+func buildBillTreeNode(stub shim.ChaincodeStubInterface, bill *Bill, visited map[string]bool) (*BillTreeNode, float64, error) {
+	if visited[bill.BillID] {
+		return nil, 0, errCorrupt(billTable.Name, bill.BillID, "circular child of bill")
+	}
+	visited[bill.BillID] = true
+
+	node := &BillTreeNode{
+		BillID:     bill.BillID,
+		Amount:     bill.Amount,
+		AmountUnit: bill.AmountUnit,
+		Owner:      bill.Owner,
+		OwnerName:  bill.OwnerName,
+		State:      bill.State,
+		SplitCount: bill.SplitCount,
+	}
+
+	bc, err := NewBillChildRepo(stub).Find(bill.BillID)
+	if err != nil {
+		return nil, 0, err
+	} else if bc == nil || len(bc.Childs) == 0 {
+		return node, bill.Amount, nil
+	}
+
+	var leafSum float64
+	repo := NewBillRepo(stub)
+	for _, childID := range bc.Childs {
+		child, err := repo.Get(childID)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		childNode, sum, err := buildBillTreeNode(stub, child, visited)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		node.Childs = append(node.Childs, childNode)
+		leafSum += sum
+	}
+
+	return node, leafSum, nil
+}
+
+// 以合同为根构造拆分树，合同生成的每张原始票据为根的子节点，返回节点、原始票据金额之和及叶子票据金额之和
+func buildContractTreeNode(stub shim.ChaincodeStubInterface, ct *Contract, visited map[string]bool) (*BillTreeNode, float64, float64, error) {
+	node := &BillTreeNode{
+		BillID:     ct.ContractID,
+		Amount:     ct.Amount,
+		AmountUnit: ct.AmountUnit,
+		Owner:      ct.Owner,
+		OwnerName:  ct.OwnerName,
+		State:      ct.State,
+		IsContract: true,
+	}
+
+	billIDs, err := queryAllIndexIDs(stub, "parent~child", []string{ct.ContractID})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var billedSum, leafSum float64
+	repo := NewBillRepo(stub)
+	for _, billID := range billIDs {
+		bill, err := repo.Get(billID)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		// 索引与票据记录不一致时以票据记录为准
+		if bill.ParentID != ct.ContractID {
+			continue
+		}
+
+		child, sum, err := buildBillTreeNode(stub, bill, visited)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		node.Childs = append(node.Childs, child)
+		billedSum += bill.Amount
+		leafSum += sum
+	}
+
+	return node, billedSum, leafSum, nil
+}
+
+//queryBillTree 查询票据所在的完整拆分树：向上找到原始合同，向下包含合同生成的所有票据及其子票据
+//  args: 0 - Bill ID
+func (sfb *SupplyFinance) queryBillTree(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	repo := NewBillRepo(stub)
+	bill, err := repo.Get(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := findRootBill(repo, bill)
+	if err != nil {
+		return nil, err
+	}
+
+	var ct *Contract
+	if root.ParentID != "" {
+		ct, err = NewContractRepo(stub).Find(root.ParentID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var tree BillTree
+	visited := make(map[string]bool)
+	if ct == nil {
+		tree.Root, tree.LeafAmountSum, err = buildBillTreeNode(stub, root, visited)
+		tree.RootAmount = root.Amount
+	} else {
+		// 合同可以分批生成多张票据，以合同为根包含其生成的所有票据
+		tree.ContractID = ct.ContractID
+		tree.Root, tree.RootAmount, tree.LeafAmountSum, err = buildContractTreeNode(stub, ct, visited)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tree.Balanced = amountEqual(tree.RootAmount, tree.LeafAmountSum)
+
+	return tree, nil
+}
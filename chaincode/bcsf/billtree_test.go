@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBillTreeIncludesAllContractBills(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+
+	mustOK(t, s.invoke("issueContract", toJSON(t, testContract("c1", "yuan", 5000))))
+	mustOK(t, s.invoke("endorseContract", "c1", "draween", "", fmt.Sprint(s.now), "1"))
+	mustOK(t, s.invoke("issueContractBill", "c1", "ownern", "b1", "3000", fmt.Sprint(s.now)))
+	mustOK(t, s.invoke("issueContractBill", "c1", "ownern", "b2", "1000", fmt.Sprint(s.now)))
+
+	split := fmt.Sprintf(`{"bill_id":"b1","owner_name":"ownern","split_date":%d,"child_bills":[{"bill_id":"b11","owner":"owner","owner_name":"ownern","amount":2000},{"bill_id":"b12","owner":"owner","owner_name":"ownern","amount":1000}]}`, s.now)
+	mustOK(t, s.invoke("splitBill", split))
+
+	// 从另一张原始票据的子票据查询，同样包含合同生成的所有票据
+	var tree BillTree
+	decodeData(t, mustOK(t, s.invoke("queryBillTree", "b12")), &tree)
+	if tree.ContractID != "c1" || !tree.Root.IsContract || tree.Root.BillID != "c1" || len(tree.Root.Childs) != 2 {
+		t.Fatalf("unexpected root: %+v", tree.Root)
+	}
+	if tree.RootAmount != 4000 || tree.LeafAmountSum != 4000 || !tree.Balanced {
+		t.Fatalf("unexpected amounts: %+v", tree)
+	}
+
+	// 不是由合同生成的票据以原始票据为根
+	s.issueEndorsedBill("b3", "drawee", "owner", 500)
+	var billTree BillTree
+	decodeData(t, mustOK(t, s.invoke("queryBillTree", "b3")), &billTree)
+	if billTree.ContractID != "" || billTree.Root.IsContract || billTree.Root.BillID != "b3" || billTree.RootAmount != 500 {
+		t.Fatalf("unexpected tree: %+v", billTree)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+// 复合键的前缀，范围查询不返回复合键
+const compositeKeyNamespace = "\x00"
+
+//bufferedStub 缓冲写操作的stub，读取时先读缓冲再读下层stub，commit时按写入顺序写入下层stub，丢弃时不影响账本
+// 范围查询和复合键查询合并缓冲中的写入；富查询无法合并，返回错误
+type bufferedStub struct {
+	shim.ChaincodeStubInterface
+	values map[string][]byte // key -> 写入的值，删除时为nil
+	params map[string][]byte // key -> 记录级背书策略
+	keys   []string          // 写入顺序
+	pkeys  []string          // 背书策略写入顺序
+}
+
+func newBufferedStub(stub shim.ChaincodeStubInterface) *bufferedStub {
+	return &bufferedStub{
+		ChaincodeStubInterface: stub,
+		values:                 make(map[string][]byte),
+		params:                 make(map[string][]byte),
+	}
+}
+
+func (s *bufferedStub) GetState(key string) ([]byte, error) {
+	if v, ok := s.values[key]; ok {
+		return v, nil
+	}
+
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+func (s *bufferedStub) PutState(key string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+
+	s.set(key, value)
+	return nil
+}
+
+func (s *bufferedStub) DelState(key string) error {
+	s.set(key, nil)
+	return nil
+}
+
+func (s *bufferedStub) set(key string, value []byte) {
+	if _, ok := s.values[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.values[key] = value
+}
+
+func (s *bufferedStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	it, err := s.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.merge(it, func(key string) bool {
+		return !strings.HasPrefix(key, compositeKeyNamespace) && key >= startKey && (endKey == "" || key < endKey)
+	})
+}
+
+func (s *bufferedStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.merge(it, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+func (s *bufferedStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, newError(ErrInternal, "rich query can't see the buffered writes of a batch").With("query", query)
+}
+
+// 合并下层查询结果和缓冲中范围内的写入，按key排序返回；缓冲中删除的key不返回
+func (s *bufferedStub) merge(it shim.StateQueryIteratorInterface, inRange func(key string) bool) (shim.StateQueryIteratorInterface, error) {
+	defer it.Close()
+
+	kvs := make(map[string]*queryresult.KV)
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		kvs[kv.Key] = kv
+	}
+
+	for _, key := range s.keys {
+		if !inRange(key) {
+			continue
+		}
+
+		if v := s.values[key]; v == nil {
+			delete(kvs, key)
+		} else {
+			kvs[key] = &queryresult.KV{Key: key, Value: v}
+		}
+	}
+
+	result := &kvIterator{kvs: make([]*queryresult.KV, 0, len(kvs))}
+	for _, kv := range kvs {
+		result.kvs = append(result.kvs, kv)
+	}
+	sort.Slice(result.kvs, func(i, j int) bool {
+		return result.kvs[i].Key < result.kvs[j].Key
+	})
+
+	return result, nil
+}
+
+func (s *bufferedStub) GetStateValidationParameter(key string) ([]byte, error) {
+	if ep, ok := s.params[key]; ok {
+		return ep, nil
+	}
+
+	return s.ChaincodeStubInterface.GetStateValidationParameter(key)
+}
+
+func (s *bufferedStub) SetStateValidationParameter(key string, ep []byte) error {
+	if _, ok := s.params[key]; !ok {
+		s.pkeys = append(s.pkeys, key)
+	}
+	s.params[key] = ep
+	return nil
+}
+
+// 把缓冲的写操作写入下层stub并清空缓冲
+func (s *bufferedStub) commit() error {
+	for _, key := range s.keys {
+		var err error
+		if v := s.values[key]; v == nil {
+			err = s.ChaincodeStubInterface.DelState(key)
+		} else {
+			err = s.ChaincodeStubInterface.PutState(key, v)
+		}
+
+		if err != nil {
+			return wrapError(err)
+		}
+	}
+
+	for _, key := range s.pkeys {
+		if err := s.ChaincodeStubInterface.SetStateValidationParameter(key, s.params[key]); err != nil {
+			return wrapError(err)
+		}
+	}
+
+	s.values = make(map[string][]byte)
+	s.params = make(map[string][]byte)
+	s.keys = nil
+	s.pkeys = nil
+	return nil
+}
+
+//kvIterator 按内存中的结果遍历的查询迭代器
+type kvIterator struct {
+	kvs []*queryresult.KV
+	pos int
+}
+
+func (it *kvIterator) HasNext() bool {
+	return it.pos < len(it.kvs)
+}
+
+func (it *kvIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, newError(ErrInternal, "no more query results")
+	}
+
+	kv := it.kvs[it.pos]
+	it.pos++
+	return kv, nil
+}
+
+func (it *kvIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var configTable = tableDef{"config", "CONF_", nil}
+
+func init() {
+	registerTables(configTable)
+}
+
+// 配置只有一条记录，历史版本通过账本历史查询
+const ConfigID = "current"
+
+// 计息天数惯例
+const (
+	DayCountAct360 = "ACT/360"
+	DayCountAct365 = "ACT/365"
+	DayCount30360  = "30/360"
+)
+
+// 功能开关
+const (
+	FeatureKeyEndorsement = "key_endorsement" // 保存票据、贷款时设置记录级背书策略
+)
+
+// 未配置的功能开关取默认值
+var defaultFeatures = map[string]bool{
+	FeatureKeyEndorsement: true,
+}
+
+//SplitDefaults 核心企业没有设置拆分规则时使用的默认规则
+type SplitDefaults struct {
+	MaxSplitDepth   int32   `json:"max_split_depth"`  //原始票据最大拆分深度/次数
+	MinChildAmount  float64 `json:"min_child_amount"` //子票据最小金额，0表示不限
+	MaxChilds       int     `json:"max_child_bills"`  //单次拆分最多子票据数，0表示不限
+	AmountPrecision int32   `json:"amount_precision"` //子票据金额允许的小数位数
+}
+
+//Config 链码配置，Init时初始化，之后由管理员通过updateConfig修改，每次修改版本号加1
+type Config struct {
+	Version          int32           `json:"cfg_version" sf:"readonly"`         //配置版本号，由链码维护
+	AdminMSPs        []string        `json:"admin_msps"`                        //管理员组织的MSP ID，可以修改配置和拆分规则
+	SplitDefaults    SplitDefaults   `json:"split_defaults"`                    //默认拆分规则
+	Currencies       []string        `json:"currencies"`                        //允许的金额单位，为空时不限
+	DayCount         string          `json:"day_count" sf:"required"`           //默认计息天数惯例：ACT/360、ACT/365、30/360，还款时未传入利息时按此计算
+	TransferOfferTTL int64           `json:"transfer_offer_ttl" sf:"gt=0"`      //票据流转要约的默认有效期(毫秒)
+	GuaranteeGrace   int64           `json:"guarantee_grace_period" sf:"gte=0"` //未指定担保到期时间时，担保在还款时间之后继续有效的宽限期(毫秒)，0取默认值
+	Features         map[string]bool `json:"features"`                          //功能开关，未配置的取默认值
+	UpdateDate       int64           `json:"cfg_update_date" sf:"readonly"`     //修改时间
+	UpdateTxID       string          `json:"cfg_update_tx_id" sf:"readonly"`    //修改配置的交易ID
+}
+
+func (cfg Config) recordID() string {
+	return ConfigID
+}
+
+func (cfg Config) validate() error {
+	if len(cfg.AdminMSPs) == 0 {
+		return newError(ErrInvalidArg, "admin_msps should not be empty")
+	}
+
+	switch cfg.DayCount {
+	case DayCountAct360, DayCountAct365, DayCount30360:
+	default:
+		return newError(ErrInvalidArg, "unknown day_count: %s", cfg.DayCount).With("day_count", cfg.DayCount)
+	}
+
+	if err := cfg.splitRule("").validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 链码升级前没有配置时使用的默认配置，与原来的常量保持一致
+func defaultConfig() Config {
+	return Config{
+		AdminMSPs: []string{},
+		SplitDefaults: SplitDefaults{
+			MaxSplitDepth:   DefaultMaxSplitDepth,
+			AmountPrecision: DefaultAmountPrecision,
+		},
+		Currencies:       []string{},
+		DayCount:         DayCountAct360,
+		TransferOfferTTL: DefaultTransferOfferTTL,
+		GuaranteeGrace:   DefaultGuaranteeGrace,
+		Features:         map[string]bool{},
+	}
+}
+
+// 核心企业的默认拆分规则
+func (cfg Config) splitRule(drawee string) SplitRule {
+	return SplitRule{
+		Drawee:          drawee,
+		MaxSplitDepth:   cfg.SplitDefaults.MaxSplitDepth,
+		MinChildAmount:  cfg.SplitDefaults.MinChildAmount,
+		MaxChilds:       cfg.SplitDefaults.MaxChilds,
+		AmountPrecision: cfg.SplitDefaults.AmountPrecision,
+	}
+}
+
+// 担保宽限期，升级前保存的配置没有该项时取默认值
+func (cfg Config) guaranteeGrace() int64 {
+	if cfg.GuaranteeGrace == 0 {
+		return DefaultGuaranteeGrace
+	}
+
+	return cfg.GuaranteeGrace
+}
+
+// 功能是否开启
+func (cfg Config) enabled(feature string) bool {
+	if v, ok := cfg.Features[feature]; ok {
+		return v
+	}
+
+	return defaultFeatures[feature]
+}
+
+// 金额单位是否允许
+func (cfg Config) allowCurrency(unit string) bool {
+	if len(cfg.Currencies) == 0 {
+		return true
+	}
+
+	for _, c := range cfg.Currencies {
+		if c == unit {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 检查金额单位是否允许
+func (cfg Config) checkCurrency(unit string) error {
+	if !cfg.allowCurrency(unit) {
+		return newError(ErrInvalidArg, "the amount unit is not allowed: %s", unit).With("amount_unit", unit).With("currencies", cfg.Currencies)
+	}
+
+	return nil
+}
+
+// 组织是否为管理员
+func (cfg Config) isAdmin(mspID string) bool {
+	for _, m := range cfg.AdminMSPs {
+		if m == mspID {
+			return true
+		}
+	}
+
+	return false
+}
+
+//ConfigRepo 配置表
+type ConfigRepo struct {
+	Store
+}
+
+func NewConfigRepo(stub shim.ChaincodeStubInterface) ConfigRepo {
+	return ConfigRepo{newStore(stub, configTable)}
+}
+
+//Find 没有配置时返回nil
+func (r ConfigRepo) Find() (*Config, error) {
+	var cfg Config
+	found, err := r.Store.Find(ConfigID, &cfg)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (r ConfigRepo) Put(cfg *Config) error {
+	return r.Store.Put(ConfigID, *cfg)
+}
+
+// 读取生效的配置，没有配置时返回默认配置
+func loadConfig(stub shim.ChaincodeStubInterface) (*Config, error) {
+	cfg, err := NewConfigRepo(stub).Find()
+	if err != nil || cfg != nil {
+		return cfg, err
+	}
+
+	d := defaultConfig()
+	return &d, nil
+}
+
+// 保存新版本的配置，未配置管理员时以调用者组织为管理员
+func putConfig(stub shim.ChaincodeStubInterface, cfg *Config, old *Config) error {
+	if len(cfg.AdminMSPs) == 0 {
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return wrapError(err)
+		}
+		cfg.AdminMSPs = []string{mspID}
+	}
+
+	if cfg.Features == nil {
+		cfg.Features = map[string]bool{}
+	}
+
+	if cfg.Currencies == nil {
+		cfg.Currencies = []string{}
+	}
+
+	cfg.Version = 1
+	if old != nil {
+		cfg.Version = old.Version + 1
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return err
+	}
+	cfg.UpdateDate = now
+	cfg.UpdateTxID = stub.GetTxID()
+
+	return NewConfigRepo(stub).Put(cfg)
+}
+
+// 链码实例化或升级时初始化配置：传入配置时保存为新版本；未传入时保留已有配置，没有配置则保存默认配置
+func initConfig(stub shim.ChaincodeStubInterface, args []string) (*Config, error) {
+	if len(args) > 1 {
+		return nil, errInvalidArgCount("init", 1)
+	}
+
+	old, err := NewConfigRepo(stub).Find()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 || args[0] == "" {
+		if old != nil {
+			return old, nil
+		}
+
+		cfg := defaultConfig()
+		return &cfg, putConfig(stub, &cfg, nil)
+	}
+
+	cfg := defaultConfig()
+	err = NewObjectFromJsonString(args[0], &cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cfg, putConfig(stub, &cfg, old)
+}
+
+//updateConfig 管理员修改链码配置，传入完整的配置，版本号加1；管理员由路由检查
+//  args: 0 - {Config Object}
+func (sfb *SupplyFinance) updateConfig(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	old, err := loadConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := *args.Object(0).(*Config)
+
+	// 防止管理员把自己移出后无人可以修改配置
+	if len(cfg.AdminMSPs) == 0 {
+		return nil, newError(ErrInvalidArg, "Chaincode Invoke updateConfig failed: admin_msps should not be empty")
+	}
+
+	err = putConfig(stub, &cfg, old)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+//getConfig 查询生效的链码配置，没有配置时返回默认配置(cfg_version为0)
+//  args: 0 - "history"，可选，按时间顺序返回配置的所有版本
+func (sfb *SupplyFinance) getConfig(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	if args.Len() == 0 || args.String(0) == "" {
+		cfg, err := loadConfig(stub)
+		if err != nil {
+			return nil, err
+		}
+
+		return cfg, nil
+	}
+
+	if args.String(0) != "history" {
+		return nil, newError(ErrInvalidArg, "Chaincode Invoke getConfig failed: unknown option: %s", args.String(0)).With("arg", args.String(0))
+	}
+
+	entries, err := NewConfigRepo(stub).History(ConfigID)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]Config, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDelete {
+			continue
+		}
+
+		var cfg Config
+		if err := json.Unmarshal(e.Value, &cfg); err != nil {
+			return nil, errCorrupt(configTable.Name, ConfigID, err.Error())
+		}
+		versions = append(versions, cfg)
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// 合同未生成票据的金额
+// 升级前担保的合同(没有版本号)在担保时已按全额生成票据，视为没有剩余金额
+func (ct Contract) unbilledAmount() float64 {
+	if ct.Version == 0 && ct.State == Endorsed {
+		return 0
+	}
+
+	return ct.Amount - ct.BilledAmount
+}
+
+// 按已担保的合同生成票据，累计已生成票据的金额，全额生成后合同自动结束
+func (sfb *SupplyFinance) issueContractBillObj(stub shim.ChaincodeStubInterface, ct *Contract, bill_id string, amount float64, create_date int64) (*Bill, error) {
+	if ct.State != Endorsed {
+		return nil, errWrongState("contract", ct.State, Endorsed)
+	}
+
+	if amount <= 0 {
+		return nil, newError(ErrInvalidArg, "the amount of bill should be greater than 0").With("amount", amount)
+	}
+
+	unbilled := ct.unbilledAmount()
+	if amount > unbilled+AmountEpsilon {
+		res := newError(ErrInvalidArg, "the amount of bill exceeds the unbilled amount of contract, NO: %s", ct.ContractID)
+		return nil, res.With("amount", amount).With("unbilled_amount", unbilled)
+	}
+
+	var bill Bill
+	bill.ParentID = ct.ContractID
+	bill.BillID = bill_id
+	bill.Amount = amount
+	bill.AmountUnit = ct.AmountUnit
+	bill.IssueDate = ct.IssueDate
+	bill.DueDate = ct.DueDate
+	bill.PyeeName = ct.PyeeName
+	bill.PyeeID = ct.PyeeID
+	bill.PyeeAcct = ct.PyeeAcct
+	bill.Drawee = ct.Drawee
+	bill.DraweeName = ct.DraweeName
+	bill.Issuer = ct.Issuer
+	bill.IssuerName = ct.IssuerName
+	bill.Owner = ct.Owner
+	bill.OwnerName = ct.OwnerName
+	bill.CreateDate = create_date
+
+	err := sfb.issueBillObj(stub, &bill, -1, Endorsed)
+	if err != nil {
+		return nil, err
+	}
+
+	ct.BilledAmount += amount
+	if amountEqual(ct.BilledAmount, ct.Amount) {
+		ct.State = ContractClosed
+	}
+
+	err = NewContractRepo(stub).Put(ct)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bill, nil
+}
+
+//issueContractBill 合同持有人按已担保的合同分批生成票据，累计金额不超过合同金额
+//  args: 0 - Contract_No ; 1 - Owner Name ; 2 - Bill ID ; 3 - Amount ; 4 - Bill Created Date
+func (sfb *SupplyFinance) issueContractBill(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	ct, err := NewContractRepo(stub).Get(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if ct.OwnerName != args.String(1) {
+		return nil, errForbidden("Chaincode Invoke issueContractBill failed: owner is not same with current owner")
+	}
+
+	bill, err := sfb.issueContractBillObj(stub, ct, args.String(2), args.Float(3), args.Int(4))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"contract": ct, "bill": bill}, nil
+}
+
+//closeContract 合同持有人确认合同履行完毕，不再生成票据，已生成的票据不受影响
+//  args: 0 - Contract_No ; 1 - Owner Name
+func (sfb *SupplyFinance) closeContract(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	ct, err := NewContractRepo(stub).Get(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if ct.OwnerName != args.String(1) {
+		return nil, errForbidden("Chaincode Invoke closeContract failed: owner is not same with current owner")
+	}
+
+	err = setContractStateThenPut(stub, ct, Endorsed, ContractClosed)
+	if err != nil {
+		return nil, err
+	}
+
+	return ct, nil
+}
+
+//terminateContract 还款人终止已担保的合同，不再生成票据，已生成的票据不受影响
+//  args: 0 - Contract_No ; 1 - Drawee Name ; 2 - Terminate Reason
+func (sfb *SupplyFinance) terminateContract(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	ct, err := NewContractRepo(stub).Get(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ct.ValidateDraweeName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke terminateContract failed: drawee is not same with current drawee")
+	}
+
+	ct.RefuseReason = args.String(2)
+	err = setContractStateThenPut(stub, ct, Endorsed, ContractTerminated)
+	if err != nil {
+		return nil, err
+	}
+
+	return ct, nil
+}
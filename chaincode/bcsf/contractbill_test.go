@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// 上传并担保合同，担保时不生成票据
+func (s *testStub) endorsedContract(contractID string, amount float64) {
+	mustOK(s.t, s.invoke("issueContract", toJSON(s.t, testContract(contractID, "yuan", amount))))
+	mustOK(s.t, s.invoke("endorseContract", contractID, "draween", "", "0", "1"))
+}
+
+// 合同生成票据的返回结果
+type contractBillResult struct {
+	Contract Contract `json:"contract"`
+	Bill     Bill     `json:"bill"`
+}
+
+func TestIssueContractBillsInTranches(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.endorsedContract("c1", 5000)
+	now := fmt.Sprint(s.now)
+
+	mustFail(t, s.invoke("issueContractBill", "c1", "payeen", "b1", "3000", now), ErrForbidden)
+	ret := mustFail(t, s.invoke("issueContractBill", "c1", "ownern", "b1", "6000", now), ErrInvalidArg)
+	if ret.Details["unbilled_amount"] != 5000.0 {
+		t.Fatalf("unexpected details: %+v", ret.Details)
+	}
+
+	var res contractBillResult
+	decodeData(t, mustOK(t, s.invoke("issueContractBill", "c1", "ownern", "b1", "3000", now)), &res)
+	if res.Contract.BilledAmount != 3000 || res.Contract.State != Endorsed || res.Bill.ParentID != "c1" || res.Bill.State != Endorsed {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	// 全额生成票据后合同自动结束
+	decodeData(t, mustOK(t, s.invoke("issueContractBill", "c1", "ownern", "b2", "2000", now)), &res)
+	if res.Contract.BilledAmount != 5000 || res.Contract.State != ContractClosed {
+		t.Fatalf("unexpected contract: %+v", res.Contract)
+	}
+	mustFail(t, s.invoke("issueContractBill", "c1", "ownern", "b3", "1", now), ErrWrongState)
+
+	// 升级前担保的合同已按全额生成票据
+	legacy := testContract("c2", "yuan", 1000)
+	legacy.State = Endorsed
+	s.putRaw(contractTable, "c2", legacy)
+	mustFail(t, s.invoke("issueContractBill", "c2", "ownern", "b4", "1", now), ErrInvalidArg)
+}
+
+func TestCloseAndTerminateContract(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.endorsedContract("c1", 5000)
+	s.endorsedContract("c2", 5000)
+	now := fmt.Sprint(s.now)
+	mustOK(t, s.invoke("issueContractBill", "c1", "ownern", "b1", "1000", now))
+
+	// 持有人确认履行完毕后不能再生成票据，已生成的票据不受影响
+	mustFail(t, s.invoke("closeContract", "c1", "draween"), ErrForbidden)
+	var ct Contract
+	decodeData(t, mustOK(t, s.invoke("closeContract", "c1", "ownern")), &ct)
+	if ct.State != ContractClosed {
+		t.Fatalf("unexpected contract state: %s", ct.State)
+	}
+	mustFail(t, s.invoke("issueContractBill", "c1", "ownern", "b2", "1000", now), ErrWrongState)
+
+	var bill Bill
+	s.getRecord(billTable, "b1", &bill)
+	if bill.State != Endorsed {
+		t.Fatalf("unexpected bill state: %s", bill.State)
+	}
+
+	// 还款人终止合同
+	mustFail(t, s.invoke("terminateContract", "c2", "ownern", "delivery failed"), ErrForbidden)
+	decodeData(t, mustOK(t, s.invoke("terminateContract", "c2", "draween", "delivery failed")), &ct)
+	if ct.State != ContractTerminated || ct.RefuseReason != "delivery failed" {
+		t.Fatalf("unexpected contract: %+v", ct)
+	}
+	mustFail(t, s.invoke("closeContract", "c2", "ownern"), ErrWrongState)
+	mustFail(t, s.invoke("issueContractBill", "c2", "ownern", "b3", "1000", now), ErrWrongState)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var contractVersionTable = tableDef{"contract_version", "CTVR_", contractVersionIndexes}
+
+var contractVersionIndexes = []indexDef{
+	{"contract~version", "cv_contract_id"}, // 合同 -> 合同版本
+}
+
+func init() {
+	registerTables(contractVersionTable)
+}
+
+// 修改合同时不比较的字段，由链码维护
+var contractManagedFields = map[string]bool{
+	"ct_state":            true,
+	"refused_reason":      true,
+	"ct_version":          true,
+	"ct_endorsed_version": true,
+	"ct_create_date":      true,
+	"billed_amount":       true,
+}
+
+//FieldChange 合同字段的一处修改
+type FieldChange struct {
+	Field string      `json:"field"` //字段名，即JSON字段
+	Old   interface{} `json:"old"`   //修改前的值
+	New   interface{} `json:"new"`   //修改后的值
+}
+
+//ContractVersion 合同的一个版本，合同上传及每次修改时保存
+type ContractVersion struct {
+	ContractID string        `json:"cv_contract_id"` //合同号
+	Version    int32         `json:"cv_version"`     //版本号，从1开始
+	HashID     string        `json:"cv_hash_id"`     //该版本合同内容的hash值
+	Contract   Contract      `json:"contract"`       //该版本的合同内容
+	Changes    []FieldChange `json:"changes"`        //相对上一版本修改的字段，第一个版本为空
+	CreateDate int64         `json:"cv_create_date"` //版本生成的交易时间
+}
+
+func contractVersionID(contractID string, version int32) string {
+	return contractID + "_" + strconv.Itoa(int(version))
+}
+
+func (cv ContractVersion) recordID() string {
+	return contractVersionID(cv.ContractID, cv.Version)
+}
+
+//ContractVersionRepo 合同版本表
+type ContractVersionRepo struct {
+	Store
+}
+
+func NewContractVersionRepo(stub shim.ChaincodeStubInterface) ContractVersionRepo {
+	return ContractVersionRepo{newStore(stub, contractVersionTable)}
+}
+
+func (r ContractVersionRepo) Get(contractID string, version int32) (*ContractVersion, error) {
+	var cv ContractVersion
+	if err := r.Store.Get(contractVersionID(contractID, version), &cv); err != nil {
+		return nil, err
+	}
+
+	return &cv, nil
+}
+
+func (r ContractVersionRepo) Put(cv *ContractVersion) error {
+	return r.Store.Put(cv.recordID(), *cv)
+}
+
+// 合同当前版本号，升级前上传的合同没有版本号，视为版本1
+func (ct Contract) currentVersion() int32 {
+	if ct.Version == 0 {
+		return 1
+	}
+
+	return ct.Version
+}
+
+// 比较两个版本的合同，返回修改的字段
+func diffContract(old, new *Contract) []FieldChange {
+	changes := make([]FieldChange, 0)
+
+	ov := reflect.ValueOf(*old)
+	nv := reflect.ValueOf(*new)
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if field == "" || contractManagedFields[field] {
+			continue
+		}
+
+		o := ov.Field(i).Interface()
+		n := nv.Field(i).Interface()
+		if !reflect.DeepEqual(o, n) {
+			changes = append(changes, FieldChange{Field: field, Old: o, New: n})
+		}
+	}
+
+	return changes
+}
+
+// 保存合同当前版本
+func putContractVersion(stub shim.ChaincodeStubInterface, ct *Contract, changes []FieldChange) (*ContractVersion, error) {
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if changes == nil {
+		changes = []FieldChange{}
+	}
+
+	cv := ContractVersion{ContractID: ct.ContractID, Version: ct.currentVersion(), HashID: ct.HashID, Contract: *ct, Changes: changes, CreateDate: now}
+	err = NewContractVersionRepo(stub).Put(&cv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cv, nil
+}
+
+//amendContract 发起人修改未担保或被拒绝的合同，生成新版本，合同恢复为uploaded等待还款人担保
+//  args: 0 - {Contract object}
+func (sfb *SupplyFinance) amendContract(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	amended := *args.Object(0).(*Contract)
+
+	// 金额单位须为配置允许的币种，与上传合同一致
+	cfg, err := loadConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.checkCurrency(amended.AmountUnit)
+	if err != nil {
+		return nil, err
+	}
+
+	ct, err := NewContractRepo(stub).Get(amended.ContractID)
+	if err != nil {
+		return nil, err
+	}
+
+	if ct.IssuerName != amended.IssuerName || ct.Issuer != amended.Issuer {
+		return nil, errForbidden("Chaincode Invoke amendContract failed: the issuer is not same with current's")
+	}
+
+	if ct.State != ContractUploaded && ct.State != Rejected {
+		return nil, errWrongState("contract", ct.State, ContractUploaded, Rejected)
+	}
+
+	changes := diffContract(ct, &amended)
+	if len(changes) == 0 {
+		return nil, newError(ErrInvalidArg, "Chaincode Invoke amendContract failed: nothing is changed")
+	}
+
+	// 升级前上传的合同没有保存版本，先保存修改前的版本
+	exist, err := NewContractVersionRepo(stub).Exists(contractVersionID(ct.ContractID, ct.currentVersion()))
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		if _, err = putContractVersion(stub, ct, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	amended.Version = ct.currentVersion() + 1
+	amended.State = ContractUploaded
+	amended.RefuseReason = ""
+	amended.CreateDate = ct.CreateDate
+	amended.BilledAmount = ct.BilledAmount
+
+	cv, err := putContractVersion(stub, &amended, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = NewContractRepo(stub).Put(&amended)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"contract": amended, "version": cv}, nil
+}
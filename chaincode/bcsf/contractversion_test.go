@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 合同参数，到期时间为一年后
+func testContract(contractID, unit string, amount float64) Contract {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return Contract{
+		ContractID: contractID,
+		HashID:     "h1",
+		Amount:     amount,
+		AmountUnit: unit,
+		IssueDate:  now,
+		DueDate:    now + 365*testDay,
+		PyeeName:   "ownern",
+		Drawee:     "drawee",
+		DraweeName: "draween",
+		Issuer:     "owner",
+		IssuerName: "ownern",
+		Owner:      "owner",
+		OwnerName:  "ownern",
+	}
+}
+
+func TestAmendContractChecksCurrency(t *testing.T) {
+	s := newTestStub(t)
+	admin := newIdentity(t, testAdminMSP, "admin", "")
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+
+	mustOK(t, s.as(admin).invoke("updateConfig", `{"admin_msps":["`+testAdminMSP+`"],"split_defaults":{"max_split_depth":1,"amount_precision":2},"currencies":["yuan"],"day_count":"ACT/360","transfer_offer_ttl":604800000}`))
+	mustOK(t, s.as(owner).invoke("issueContract", toJSON(t, testContract("c1", "yuan", 1000))))
+
+	// 修改后的金额单位同样须为配置允许的币种
+	mustFail(t, s.invoke("amendContract", toJSON(t, testContract("c1", "usd", 1000))), ErrInvalidArg)
+
+	var res struct {
+		Contract Contract `json:"contract"`
+	}
+	decodeData(t, mustOK(t, s.invoke("amendContract", toJSON(t, testContract("c1", "yuan", 2000)))), &res)
+	if res.Contract.Amount != 2000 || res.Contract.Version != 2 {
+		t.Fatalf("unexpected contract: %+v", res.Contract)
+	}
+}
+
+func TestAmendContractVersions(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	ct := testContract("c1", "yuan", 1000)
+	mustOK(t, s.invoke("issueContract", toJSON(t, ct)))
+
+	// 只有发起人可以修改，且须有修改
+	other := ct
+	other.Issuer = "payee"
+	mustFail(t, s.invoke("amendContract", toJSON(t, other)), ErrForbidden)
+	mustFail(t, s.invoke("amendContract", toJSON(t, ct)), ErrInvalidArg)
+
+	amended := ct
+	amended.Amount = 2000
+	amended.HashID = "h2"
+	var res struct {
+		Contract Contract        `json:"contract"`
+		Version  ContractVersion `json:"version"`
+	}
+	decodeData(t, mustOK(t, s.invoke("amendContract", toJSON(t, amended))), &res)
+	if res.Contract.Version != 2 || res.Contract.State != ContractUploaded || len(res.Version.Changes) != 2 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if res.Version.Changes[0].Field != "hash_id" || res.Version.Changes[1].Field != "ct_amount" || res.Version.Changes[1].Old != 1000.0 {
+		t.Fatalf("unexpected changes: %+v", res.Version.Changes)
+	}
+
+	// 保留之前的版本
+	var cv ContractVersion
+	s.getRecord(contractVersionTable, contractVersionID("c1", 1), &cv)
+	if cv.HashID != "h1" || cv.Contract.Amount != 1000 {
+		t.Fatalf("unexpected version 1: %+v", cv)
+	}
+
+	// 担保绑定合同版本
+	mustFail(t, s.invoke("endorseContract", "c1", "draween", "", "0", "1"), ErrWrongState)
+	decodeData(t, mustOK(t, s.invoke("endorseContract", "c1", "draween", "", "0", "2")), &res)
+	if res.Contract.State != Endorsed || res.Contract.EndorsedVersion != 2 {
+		t.Fatalf("unexpected contract: %+v", res.Contract)
+	}
+	mustFail(t, s.invoke("amendContract", toJSON(t, ct)), ErrWrongState)
+}
+
+func TestAmendRejectedContract(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	ct := testContract("c1", "yuan", 1000)
+	mustOK(t, s.invoke("issueContract", toJSON(t, ct)))
+	mustOK(t, s.invoke("rejectContract", "c1", "draween", "wrong amount"))
+
+	// 被拒绝的合同修改后恢复为uploaded
+	ct.Amount = 900
+	var res struct {
+		Contract Contract `json:"contract"`
+	}
+	decodeData(t, mustOK(t, s.invoke("amendContract", toJSON(t, ct))), &res)
+	if res.Contract.State != ContractUploaded || res.Contract.RefuseReason != "" || res.Contract.Version != 2 {
+		t.Fatalf("unexpected contract: %+v", res.Contract)
+	}
+}
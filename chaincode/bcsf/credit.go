@@ -0,0 +1,294 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var creditFacilityTable = tableDef{"credit_facility", "CRFC_", creditFacilityIndexes}
+
+var creditFacilityIndexes = []indexDef{
+	{"bank~facility", "cf_bank"},     // 金融机构 -> 授信
+	{"drawee~facility", "cf_drawee"}, // 核心企业 -> 授信
+}
+
+func init() {
+	registerTables(creditFacilityTable)
+}
+
+//CreditFacility 金融机构给核心企业的授信额度，以核心企业为还款人的票据抵押贷款占用该额度
+type CreditFacility struct {
+	Bank       string  `json:"cf_bank" sf:"excludes=_"`      //金融机构系统账号，不能包含授信ID的分隔符"_"
+	BankName   string  `json:"cf_bank_name"`                 //金融机构名称
+	Drawee     string  `json:"cf_drawee"`                    //核心企业系统账号
+	DraweeName string  `json:"cf_drawee_name"`               //核心企业名称
+	Limit      float64 `json:"credit_limit"`                 //授信额度
+	Utilized   float64 `json:"utilized" sf:"readonly"`       //已占用额度，审批贷款时增加，还款时释放，由链码维护
+	AmountUnit string  `json:"cf_amount_unit"`               //金额单位，元或美元等
+	ExpireDate int64   `json:"cf_expire_date"`               //授信到期时间，须晚于设置时间，到期后不能再占用额度
+	UpdateDate int64   `json:"cf_update_date" sf:"readonly"` //最后修改的交易时间
+}
+
+// 授信ID：金融机构系统账号_核心企业系统账号，金融机构账号不含"_"，保证不同授信的ID不重复
+func creditFacilityID(bank, drawee string) string {
+	return bank + "_" + drawee
+}
+
+func (cf CreditFacility) recordID() string {
+	return creditFacilityID(cf.Bank, cf.Drawee)
+}
+
+func (cf CreditFacility) validate() error {
+	if cf.Limit < 0 {
+		return newError(ErrInvalidArg, "credit_limit should not be negative").With("credit_limit", cf.Limit)
+	}
+
+	if cf.Utilized < -AmountEpsilon {
+		return newError(ErrInternal, "the utilized credit should not be negative").With("id", cf.recordID()).With("utilized", cf.Utilized)
+	}
+
+	return nil
+}
+
+// 剩余可用额度
+func (cf CreditFacility) available() float64 {
+	return cf.Limit - cf.Utilized
+}
+
+//CreditFacilityRepo 授信额度表
+type CreditFacilityRepo struct {
+	Store
+}
+
+func NewCreditFacilityRepo(stub shim.ChaincodeStubInterface) CreditFacilityRepo {
+	return CreditFacilityRepo{newStore(stub, creditFacilityTable)}
+}
+
+func (r CreditFacilityRepo) Get(bank, drawee string) (*CreditFacility, error) {
+	var cf CreditFacility
+	if err := r.Store.Get(creditFacilityID(bank, drawee), &cf); err != nil {
+		return nil, err
+	}
+
+	return &cf, nil
+}
+
+//Find 授信不存在时返回nil
+func (r CreditFacilityRepo) Find(bank, drawee string) (*CreditFacility, error) {
+	var cf CreditFacility
+	found, err := r.Store.Find(creditFacilityID(bank, drawee), &cf)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &cf, nil
+}
+
+func (r CreditFacilityRepo) Put(cf *CreditFacility) error {
+	return r.Store.Put(cf.recordID(), *cf)
+}
+
+// 贷款审批时占用金融机构给票据还款人的授信额度，超出额度或授信到期时拒绝；没有设置授信时不限额度
+func useCredit(stub shim.ChaincodeStubInterface, loan *Loan) error {
+	bill, err := NewBillRepo(stub).Get(loan.BillID)
+	if err != nil {
+		return err
+	}
+
+	loan.Drawee = bill.Drawee
+	repo := NewCreditFacilityRepo(stub)
+	cf, err := repo.Find(loan.Bank, bill.Drawee)
+	if err != nil {
+		return err
+	} else if cf == nil {
+		// 没有设置授信时不限额度，也不记录占用
+		return nil
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return err
+	}
+
+	// 升级前设置的授信可能没有到期时间，视为不限期
+	if cf.ExpireDate > 0 && now > cf.ExpireDate {
+		return newError(ErrExpired, "the credit facility is expired, NO: %s", cf.recordID()).With("id", cf.recordID()).With("expire_date", cf.ExpireDate)
+	}
+
+	if cf.AmountUnit != loan.AmountUnit {
+		res := newError(ErrInvalidArg, "the amount unit of loan is not same with the credit facility's")
+		return res.With("ln_amount_unit", loan.AmountUnit).With("cf_amount_unit", cf.AmountUnit)
+	}
+
+	if loan.Amount > cf.available()+AmountEpsilon {
+		res := newError(ErrCreditExceeded, "the loan amount exceeds the available credit, NO: %s", cf.recordID())
+		return res.With("id", cf.recordID()).With("ln_amount", loan.Amount).With("available", cf.available())
+	}
+
+	cf.Utilized += loan.Amount
+	cf.UpdateDate = now
+	err = repo.Put(cf)
+	if err != nil {
+		return err
+	}
+
+	loan.CreditUsed = loan.Amount
+	return nil
+}
+
+// 贷款结束时释放占用的授信额度，授信到期后同样释放
+func releaseCredit(stub shim.ChaincodeStubInterface, loan *Loan) error {
+	if loan.CreditUsed <= 0 {
+		return nil
+	}
+
+	repo := NewCreditFacilityRepo(stub)
+	cf, err := repo.Get(loan.Bank, loan.Drawee)
+	if err != nil {
+		return err
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return err
+	}
+
+	cf.Utilized -= loan.CreditUsed
+	if cf.Utilized < 0 {
+		cf.Utilized = 0
+	}
+	cf.UpdateDate = now
+	err = repo.Put(cf)
+	if err != nil {
+		return err
+	}
+
+	loan.CreditUsed = 0
+	return nil
+}
+
+//setCreditFacility 金融机构设置给核心企业的授信额度，已占用额度保持不变，额度可以小于已占用额度，此时不能再审批新的贷款；金融机构须登记在调用者组织下
+//  args: 0 - {CreditFacility object}
+func (sfb *SupplyFinance) setCreditFacility(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	cf := *args.Object(0).(*CreditFacility)
+
+	if cf.Bank == "" || cf.Drawee == "" {
+		return nil, newError(ErrInvalidArg, "Chaincode Invoke setCreditFacility failed: cf_bank and cf_drawee should not be empty")
+	}
+
+	// 只能设置调用者组织登记的金融机构的授信
+	err := requireParticipant(stub, cf.Bank)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := NewCreditFacilityRepo(stub)
+	old, err := repo.Find(cf.Bank, cf.Drawee)
+	if err != nil {
+		return nil, err
+	}
+
+	if old != nil {
+		if old.Utilized > 0 && old.AmountUnit != cf.AmountUnit {
+			return nil, newError(ErrInvalidArg, "Chaincode Invoke setCreditFacility failed: the amount unit can't be changed while the credit is utilized").With("cf_amount_unit", old.AmountUnit)
+		}
+		cf.Utilized = old.Utilized
+	}
+
+	cf.UpdateDate, err = getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if cf.ExpireDate <= cf.UpdateDate {
+		return nil, newError(ErrInvalidArg, "Chaincode Invoke setCreditFacility failed: cf_expire_date should be later than now").With("cf_expire_date", cf.ExpireDate)
+	}
+
+	err = repo.Put(&cf)
+	if err != nil {
+		return nil, err
+	}
+
+	return cf, nil
+}
+
+//Exposure 金融机构的风险敞口，按还款人和担保方汇总未还贷款本金，金额按金额单位分别汇总
+type Exposure struct {
+	Bank        string                        `json:"bank"`         //金融机构系统账号
+	ByDrawee    map[string]map[string]float64 `json:"by_drawee"`    //还款人 -> 金额单位 -> 未还本金
+	ByGuarantor map[string]map[string]float64 `json:"by_guarantor"` //担保方 -> 金额单位 -> 未还本金
+	Facilities  []CreditFacility              `json:"facilities"`   //该金融机构的授信
+}
+
+// 未还款的贷款状态
+var outstandingLoanStates = map[string]bool{
+	LoanApproved: true,
+	LoanLoaned:   true,
+	LoanCalled:   true,
+}
+
+func addExposure(m map[string]map[string]float64, party, unit string, amount float64) {
+	if party == "" {
+		return
+	}
+
+	if m[party] == nil {
+		m[party] = map[string]float64{}
+	}
+	m[party][unit] += amount
+}
+
+//queryExposure 查询金融机构按还款人和担保方汇总的未还贷款本金及授信使用情况
+//  args: 0 - Bank
+func (sfb *SupplyFinance) queryExposure(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	bank := args.String(0)
+	exp := Exposure{Bank: bank, ByDrawee: map[string]map[string]float64{}, ByGuarantor: map[string]map[string]float64{}, Facilities: []CreditFacility{}}
+
+	ids, err := queryAllIndexIDs(stub, "bank~loan", []string{bank})
+	if err != nil {
+		return nil, err
+	}
+
+	loanRepo := NewLoanRepo(stub)
+	billRepo := NewBillRepo(stub)
+	for _, id := range ids {
+		loan, err := loanRepo.Get(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if loan.Bank != bank || !outstandingLoanStates[loan.State] {
+			continue
+		}
+
+		// 升级前审批的贷款没有记录还款人，从票据取得
+		drawee := loan.Drawee
+		if drawee == "" {
+			bill, err := billRepo.Find(loan.BillID)
+			if err != nil {
+				return nil, err
+			} else if bill != nil {
+				drawee = bill.Drawee
+			}
+		}
+
+		addExposure(exp.ByDrawee, drawee, loan.AmountUnit, loan.Amount)
+		addExposure(exp.ByGuarantor, loan.Guarantor, loan.AmountUnit, loan.Amount)
+	}
+
+	ids, err = queryAllIndexIDs(stub, "bank~facility", []string{bank})
+	if err != nil {
+		return nil, err
+	}
+
+	store := newStore(stub, creditFacilityTable)
+	for _, id := range ids {
+		var cf CreditFacility
+		if err := store.Get(id, &cf); err != nil {
+			return nil, err
+		}
+
+		exp.Facilities = append(exp.Facilities, cf)
+	}
+
+	return exp, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestUseAndReleaseCredit(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+
+	s.register("bank1", bank)
+	mustOK(t, s.as(bank).invoke("setCreditFacility", s.facility("bank1", "drawee", 1000)))
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	s.as(owner).issueEndorsedBill("b2", "drawee", "owner", 1000)
+
+	// 占用和释放额度由链码维护，不能在申请时传入
+	mustFail(t, s.as(owner).applyLoan("l1", "b1", "owner", 800, `,"credit_used":1`), ErrInvalidArg)
+	mustFail(t, s.as(owner).applyLoan("l1", "b1", "owner", 800, `,"ln_drawee":"other"`), ErrInvalidArg)
+
+	mustOK(t, s.as(owner).applyLoan("l1", "b1", "owner", 800, ""))
+	loan := s.acceptOffer(bank, owner, "l1", "owner", "bank1", 800)
+	if loan.Drawee != "drawee" || loan.CreditUsed != 800 {
+		t.Fatalf("unexpected loan: %+v", loan)
+	}
+
+	var cf CreditFacility
+	s.getRecord(creditFacilityTable, creditFacilityID("bank1", "drawee"), &cf)
+	if math.Abs(cf.Utilized-800) > AmountEpsilon {
+		t.Fatalf("expect utilized 800, got %v", cf.Utilized)
+	}
+
+	// 剩余额度不足
+	mustOK(t, s.as(owner).applyLoan("l2", "b2", "owner", 300, ""))
+	mustOK(t, s.as(bank).invoke("submitLoanOffer", s.offer("l2", "bank1", 300)))
+	mustFail(t, s.as(owner).invoke("acceptLoanOffer", "l2", "ownern", "bank1"), ErrCreditExceeded)
+
+	// 还款后释放额度
+	mustOK(t, s.as(bank).invoke("makeLoan", "l1", "bank1n", fmt.Sprint(s.now)))
+	repay := fmt.Sprintf(`{"lr_loan_id":"l1","lr_bank_name":"bank1n","actual_repayment_date":%d,"actual_ln_amount":800}`, s.now)
+	mustOK(t, s.as(owner).invoke("repayLoan", repay))
+
+	s.getRecord(creditFacilityTable, creditFacilityID("bank1", "drawee"), &cf)
+	if math.Abs(cf.Utilized) > AmountEpsilon {
+		t.Fatalf("expect utilized 0, got %v", cf.Utilized)
+	}
+
+	loan = s.acceptOffer(bank, owner, "l2", "owner", "bank1", 300)
+	if loan.CreditUsed != 300 {
+		t.Fatalf("unexpected credit used: %v", loan.CreditUsed)
+	}
+}
+
+func TestUseCreditWithoutFacility(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+
+	// 没有授信时不限额度，也不记录占用
+	s.register("bank1", bank)
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.as(owner).applyLoan("l1", "b1", "owner", 800, ""))
+	loan := s.acceptOffer(bank, owner, "l1", "owner", "bank1", 800)
+	if loan.State != LoanApproved || loan.CreditUsed != 0 || loan.Drawee != "drawee" {
+		t.Fatalf("unexpected loan: %+v", loan)
+	}
+}
+
+func TestSetCreditFacilityRequiresRegisteredBank(t *testing.T) {
+	s := newTestStub(t)
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+	other := newIdentity(t, "OtherMSP", "other", RoleBank)
+	noRole := newIdentity(t, "BankMSP", "clerk", "")
+
+	s.register("bank1", bank)
+	mustFail(t, s.as(noRole).invoke("setCreditFacility", s.facility("bank1", "drawee", 1000)), ErrForbidden)
+	mustFail(t, s.as(other).invoke("setCreditFacility", s.facility("bank1", "drawee", 1000)), ErrForbidden)
+	mustFail(t, s.as(bank).invoke("setCreditFacility", s.facility("bank2", "drawee", 1000)), ErrForbidden)
+
+	// 金融机构账号不能包含ID分隔符，否则"a_b"+"c"与"a"+"b_c"的ID相同
+	mustFail(t, s.as(bank).invoke("setCreditFacility", s.facility("bank1_x", "drawee", 1000)), ErrInvalidArg)
+	mustFail(t, s.as(bank).invoke("submitLoanOffer", s.offer("l1", "bank1_x", 100)), ErrInvalidArg)
+
+	mustOK(t, s.as(bank).invoke("setCreditFacility", s.facility("bank1", "drawee", 1000)))
+}
+
+func TestCreditFacilityFields(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+	s.register("bank1", bank)
+
+	// 占用额度和修改时间由链码维护
+	withField := func(field string) string {
+		f := s.facility("bank1", "drawee", 1000)
+		return f[:len(f)-1] + "," + field + "}"
+	}
+	mustFail(t, s.as(bank).invoke("setCreditFacility", withField(`"utilized":500`)), ErrInvalidArg)
+	mustFail(t, s.as(bank).invoke("setCreditFacility", withField(`"cf_update_date":1`)), ErrInvalidArg)
+
+	// 到期时间须晚于当前时间
+	expired := fmt.Sprintf(`{"cf_bank":"bank1","cf_drawee":"drawee","credit_limit":1000,"cf_amount_unit":"yuan","cf_expire_date":%d}`, s.now)
+	mustFail(t, s.as(bank).invoke("setCreditFacility", expired), ErrInvalidArg)
+	mustFail(t, s.as(bank).invoke("setCreditFacility", `{"cf_bank":"bank1","cf_drawee":"drawee","credit_limit":1000,"cf_amount_unit":"yuan"}`), ErrInvalidArg)
+
+	// 升级前没有到期时间的授信不限期
+	s.putRaw(creditFacilityTable, creditFacilityID("bank1", "drawee"), CreditFacility{Bank: "bank1", Drawee: "drawee", Limit: 1000, AmountUnit: "yuan"})
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.as(owner).applyLoan("l1", "b1", "owner", 800, ""))
+	if loan := s.acceptOffer(bank, owner, "l1", "owner", "bank1", 800); loan.CreditUsed != 800 {
+		t.Fatalf("unexpected credit used: %v", loan.CreditUsed)
+	}
+}
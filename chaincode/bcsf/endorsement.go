@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/statebased"
+)
+
+var participantMSPTable = tableDef{"participant_msp", "PMSP_", nil}
+
+func init() {
+	registerTables(participantMSPTable)
+}
+
+//ParticipantMSP 参与方系统账号所属的组织，用于设置记录级背书策略
+type ParticipantMSP struct {
+	Participant string `json:"pm_participant"` //参与方系统账号
+	MSPID       string `json:"pm_msp"`         //参与方所属组织的MSP ID
+	UpdateDate  int64  `json:"pm_update_date"` //登记时间
+}
+
+func (pm ParticipantMSP) recordID() string {
+	return pm.Participant
+}
+
+//ParticipantMSPRepo 参与方组织登记表
+type ParticipantMSPRepo struct {
+	Store
+}
+
+func NewParticipantMSPRepo(stub shim.ChaincodeStubInterface) ParticipantMSPRepo {
+	return ParticipantMSPRepo{newStore(stub, participantMSPTable)}
+}
+
+func (r ParticipantMSPRepo) Find(participant string) (*ParticipantMSP, error) {
+	var pm ParticipantMSP
+	found, err := r.Store.Find(participant, &pm)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &pm, nil
+}
+
+func (r ParticipantMSPRepo) Put(pm *ParticipantMSP) error {
+	return r.Store.Put(pm.Participant, *pm)
+}
+
+//endorsedRecord 须由相关参与方所属组织背书修改的记录，保存时按参与方设置记录级背书策略
+type endorsedRecord interface {
+	endorsers() []string
+}
+
+// 票据须由还款人和持票人所属组织背书，流转后随持票人更新
+func (bl Bill) endorsers() []string {
+	return []string{bl.Drawee, bl.Owner}
+}
+
+// 贷款须由放贷金融机构所属组织背书，审批前没有金融机构时沿用链码背书策略
+func (ln Loan) endorsers() []string {
+	return []string{ln.Bank}
+}
+
+// 按参与方所属组织设置key的背书策略，功能开关key_endorsement关闭时不设置；参与方都未登记组织时保持原策略，策略不变时不重复写入
+func setKeyEndorsement(stub shim.ChaincodeStubInterface, key string, participants []string) error {
+	cfg, err := loadConfig(stub)
+	if err != nil || !cfg.enabled(FeatureKeyEndorsement) {
+		return err
+	}
+
+	repo := NewParticipantMSPRepo(stub)
+	orgs := make([]string, 0, len(participants))
+	seen := make(map[string]bool)
+	for _, p := range participants {
+		if p == "" {
+			continue
+		}
+
+		pm, err := repo.Find(p)
+		if err != nil {
+			return err
+		}
+
+		if pm != nil && !seen[pm.MSPID] {
+			seen[pm.MSPID] = true
+			orgs = append(orgs, pm.MSPID)
+		}
+	}
+
+	if len(orgs) == 0 {
+		return nil
+	}
+
+	ep, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	err = ep.AddOrgs(statebased.RoleTypePeer, orgs...)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	policy, err := ep.Policy()
+	if err != nil {
+		return wrapError(err)
+	}
+
+	old, err := stub.GetStateValidationParameter(key)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if bytes.Equal(old, policy) {
+		return nil
+	}
+
+	err = stub.SetStateValidationParameter(key, policy)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return nil
+}
+
+//registerParticipant 管理员登记参与方系统账号所属的组织；已登记的账号不能改登记到其他组织；管理员由路由检查
+//  args: 0 - Participant ; 1 - MSP ID
+func (sfb *SupplyFinance) registerParticipant(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	if args.String(0) == "" || args.String(1) == "" {
+		return nil, newError(ErrInvalidArg, "Chaincode Invoke registerParticipant failed: participant and msp_id should not be empty")
+	}
+
+	// 登记的组织用于授权金融机构等参与方的操作
+	mspID := args.String(1)
+
+	repo := NewParticipantMSPRepo(stub)
+	old, err := repo.Find(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if old != nil && old.MSPID != mspID {
+		return nil, errForbidden("Chaincode Invoke registerParticipant failed: the participant has been registered by another organization").With("pm_msp", old.MSPID)
+	}
+
+	pm := ParticipantMSP{Participant: args.String(0), MSPID: mspID}
+	pm.UpdateDate, err = getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.Put(&pm)
+	if err != nil {
+		return nil, err
+	}
+
+	return pm, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRegisterParticipant(t *testing.T) {
+	s := newTestStub(t)
+	admin := newIdentity(t, testAdminMSP, "admin", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+
+	// 只有管理员可以登记，参与方不能自行登记到自己的组织
+	mustFail(t, s.as(bank).invoke("registerParticipant", "bank1", "BankMSP"), ErrForbidden)
+	mustFail(t, s.as(admin).invoke("registerParticipant", "bank1", ""), ErrInvalidArg)
+
+	var pm ParticipantMSP
+	decodeData(t, mustOK(t, s.as(admin).invoke("registerParticipant", "bank1", "BankMSP")), &pm)
+	if pm.Participant != "bank1" || pm.MSPID != "BankMSP" || pm.UpdateDate != s.now {
+		t.Fatalf("unexpected registration: %+v", pm)
+	}
+
+	// 重复登记到同一组织不影响，不能改登记到其他组织
+	mustOK(t, s.as(admin).invoke("registerParticipant", "bank1", "BankMSP"))
+	mustFail(t, s.as(admin).invoke("registerParticipant", "bank1", "OtherMSP"), ErrForbidden)
+
+	s.getRecord(participantMSPTable, "bank1", &pm)
+	if pm.MSPID != "BankMSP" {
+		t.Fatalf("unexpected msp: %s", pm.MSPID)
+	}
+}
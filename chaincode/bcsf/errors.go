@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 错误码，客户端SDK依据该值判断错误类型，取值保持稳定
+const (
+	ErrInternal       = "INTERNAL"        // 链码内部错误，如读写账本失败
+	ErrInvalidArg     = "INVALID_ARG"     // 参数个数或格式错误
+	ErrNotFound       = "NOT_FOUND"       // 记录不存在
+	ErrWrongState     = "WRONG_STATE"     // 记录当前状态不允许该操作
+	ErrForbidden      = "FORBIDDEN"       // 调用者无权操作该记录
+	ErrDuplicate      = "DUPLICATE"       // 记录已存在
+	ErrExpired        = "EXPIRED"         // 票据或贷款已过期
+	ErrCreditExceeded = "CREDIT_EXCEEDED" // 超出金融机构给核心企业的授信额度
+	ErrCorrupt        = "CORRUPT_RECORD"  // 账本中的记录无法解析或缺少主键
+	ErrUnknownMethod  = "UNKNOWN_METHOD"  // 链码不支持的方法
+	ErrReadOnly       = "READ_ONLY"       // 查询函数写账本，或写函数在只评估不提交的调用中执行
+	ErrBatchFailed    = "BATCH_FAILED"    // 原子批量操作中有条目失败，全部不生效，Details中返回每条结果
+)
+
+//sfError 带错误码和详细信息的链码错误
+type sfError struct {
+	Code    string                 // 错误码
+	Message string                 // 错误描述
+	Details map[string]interface{} // 机器可读的详细信息，比如记录的当前状态
+}
+
+func (e *sfError) Error() string {
+	return e.Message
+}
+
+//With 添加详细信息
+func (e *sfError) With(key string, value interface{}) *sfError {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+
+	return e
+}
+
+func newError(code string, format string, a ...interface{}) *sfError {
+	return &sfError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// 将账本读写等底层错误包装为内部错误，已经是sfError的保持不变
+func wrapError(err error) *sfError {
+	if e, ok := err.(*sfError); ok {
+		return e
+	}
+
+	return newError(ErrInternal, "%s", err.Error())
+}
+
+func errInvalidArgCount(function string, expected int) *sfError {
+	return newError(ErrInvalidArg, "Chaincode Invoke %s args count expecting %d", function, expected).With("expected_args", expected)
+}
+
+func errNotFound(table, id string) *sfError {
+	return newError(ErrNotFound, "the %s is not existing, NO: %s", table, id).With("table", table).With("id", id)
+}
+
+func errDuplicate(table, id string) *sfError {
+	return newError(ErrDuplicate, "the %s has existing, NO: %s", table, id).With("table", table).With("id", id)
+}
+
+func errCorrupt(table, id, reason string) *sfError {
+	return newError(ErrCorrupt, "the %s record is corrupt, NO: %s", table, id).With("table", table).With("id", id).With("reason", reason)
+}
+
+func errWrongState(table, current string, expected ...string) *sfError {
+	e := newError(ErrWrongState, "due to %s's state, current state: %s", table, current).With("current_state", current)
+	if len(expected) > 0 {
+		e.With("expected_state", expected)
+	}
+
+	return e
+}
+
+func errForbidden(format string, a ...interface{}) *sfError {
+	return newError(ErrForbidden, format, a...)
+}
+
+// chaincode response结构，成功和失败均返回该结构
+type chaincodeRet struct {
+	Code        int                    // 0 success otherwise 1
+	ErrCode     string                 `json:",omitempty"` // 错误码，成功时为空
+	Description string                 //description
+	Details     map[string]interface{} `json:",omitempty"` // 错误详细信息
+	Data        interface{}            `json:",omitempty"` // 成功时返回受影响的记录或查询结果
+}
+
+// 成功的response，data为受影响的记录或查询结果
+func retSuccess(des string, data interface{}) pb.Response {
+	b, err := json.Marshal(chaincodeRet{Code: 0, Description: des, Data: data})
+	if err != nil {
+		return retError(err)
+	}
+
+	return shim.Success(b)
+}
+
+// 失败的response
+func retError(err error) pb.Response {
+	e := wrapError(err)
+
+	b, mErr := json.Marshal(chaincodeRet{Code: 1, ErrCode: e.Code, Description: e.Message, Details: e.Details})
+	if mErr != nil {
+		fmt.Println("marshal Ret failed")
+		return shim.Error(e.Message)
+	}
+
+	return shim.Error(string(b))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResponseEnvelope(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+
+	// 成功时返回受影响的记录
+	var bill Bill
+	ret := mustOK(t, s.invoke("issueBill", toJSON(t, testBill("b1", "drawee", "owner", 1000))))
+	decodeData(t, ret, &bill)
+	if ret.Code != 0 || ret.ErrCode != "" || bill.BillID != "b1" || bill.State != BillIssued {
+		t.Fatalf("unexpected response: %+v", ret)
+	}
+
+	// 失败时返回错误码和机器可读的详细信息
+	ret = mustFail(t, s.invoke("issueBill", toJSON(t, testBill("b1", "drawee", "owner", 1000))), ErrDuplicate)
+	if ret.Code != 1 || ret.Details["table"] != "bill" || ret.Details["id"] != "b1" {
+		t.Fatalf("unexpected response: %+v", ret)
+	}
+
+	mustOK(t, s.invoke("endorseBill", "b1", "draween"))
+	ret = mustFail(t, s.invoke("endorseBill", "b1", "draween"), ErrWrongState)
+	if ret.Details["current_state"] != Endorsed {
+		t.Fatalf("unexpected details: %+v", ret.Details)
+	}
+
+	ret = mustFail(t, s.invoke("queryByID", "bill", "b2"), ErrNotFound)
+	if ret.Details["id"] != "b2" {
+		t.Fatalf("unexpected details: %+v", ret.Details)
+	}
+}
+
+func TestWrapError(t *testing.T) {
+	if e := wrapError(errors.New("GetState failed")); e.Code != ErrInternal || e.Message != "GetState failed" {
+		t.Fatalf("unexpected error: %+v", e)
+	}
+
+	// 已经是sfError的保持错误码和详细信息
+	e := errWrongState("bill", Endorsed, BillIssued)
+	if wrapError(e) != e {
+		t.Fatalf("sfError should not be wrapped again")
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"math"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var guaranteeTable = tableDef{"guarantee", "GRNT_", guaranteeIndexes}
+
+var guaranteeIndexes = []indexDef{
+	{"guarantor~guarantee", "gt_guarantor"}, // 担保方 -> 担保
+}
+
+func init() {
+	registerTables(guaranteeTable)
+}
+
+// 担保状态
+const (
+	GuaranteeActive   = "active"   // 担保生效
+	GuaranteeCalled   = "called"   // 借款人违约，金融机构要求担保方承担还款责任
+	GuaranteeReleased = "released" // 贷款结束，担保解除
+	GuaranteeSettled  = "settled"  // 被要求承担责任后贷款还清，担保结束
+)
+
+// 贷款结束的状态，担保随之解除
+var loanClosedStates = map[string]bool{
+	LoanRepaid:    true,
+	LoanRefused:   true,
+	LoanWithdrawn: true,
+	Rejected:      true,
+}
+
+// 未指定担保比例时全额担保
+const DefaultCoveragePct = 100
+
+// 未指定担保到期时间时，担保在还款时间之后继续有效的默认宽限期，可通过配置guarantee_grace_period修改
+const DefaultGuaranteeGrace int64 = 30 * 24 * 3600 * THOUSAND
+
+//Guarantee 担保合同，担保方担保贷款时生成，与贷款一一对应
+type Guarantee struct {
+	LoanID           string  `json:"gt_loan_id"`          //贷款编号，同时作为担保编号
+	Guarantor        string  `json:"gt_guarantor"`        //担保方系统账号
+	GuarantorName    string  `json:"gt_guarantor_name"`   //担保方名称
+	Beneficiary      string  `json:"gt_beneficiary"`      //受益金融机构系统账号，贷款审批时确定
+	CoveragePct      float64 `json:"coverage_pct"`        //担保比例，0-100
+	GuaranteedAmount float64 `json:"guaranteed_amount"`   //担保金额，贷款金额 x 担保比例
+	AmountUnit       string  `json:"gt_amount_unit"`      //金额单位，元或美元等
+	Fee              float64 `json:"gt_fee"`              //担保费
+	ValidFrom        int64   `json:"valid_from"`          //担保生效时间
+	ValidUntil       int64   `json:"valid_until"`         //担保到期时间，到期后不能再要求担保方承担责任，0表示不限
+	State            string  `json:"gt_state"`            //担保状态
+	CalledAmount     float64 `json:"called_amount"`       //要求担保方承担的金额
+	CallDate         int64   `json:"call_date,omitempty"` //要求担保方承担责任的时间
+}
+
+func (gt Guarantee) recordID() string {
+	return gt.LoanID
+}
+
+func (gt Guarantee) validate() error {
+	if gt.State == "" {
+		return newError(ErrInvalidArg, "the state of guarantee should not be empty").With("id", gt.LoanID)
+	}
+
+	return nil
+}
+
+//GuaranteeTermsArg 担保条件参数，担保方担保贷款时传入
+type GuaranteeTermsArg struct {
+	CoveragePct float64 `json:"coverage_pct"` //担保比例，0或不传表示全额担保
+	Fee         float64 `json:"gt_fee"`       //担保费
+	ValidUntil  int64   `json:"valid_until"`  //担保到期时间，0或不传表示贷款还款时间加配置的宽限期
+}
+
+//GuaranteeRepo 担保表
+type GuaranteeRepo struct {
+	Store
+}
+
+func NewGuaranteeRepo(stub shim.ChaincodeStubInterface) GuaranteeRepo {
+	return GuaranteeRepo{newStore(stub, guaranteeTable)}
+}
+
+func (r GuaranteeRepo) Get(loanID string) (*Guarantee, error) {
+	var gt Guarantee
+	if err := r.Store.Get(loanID, &gt); err != nil {
+		return nil, err
+	}
+
+	return &gt, nil
+}
+
+func (r GuaranteeRepo) Put(gt *Guarantee) error {
+	return r.Store.Put(gt.LoanID, *gt)
+}
+
+//GetByLoan 取得贷款关联的担保合同，担保合同须属于该贷款
+func (r GuaranteeRepo) GetByLoan(loan *Loan) (*Guarantee, error) {
+	gt, err := r.Get(loan.GuaranteeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if gt.LoanID != loan.LoanID {
+		res := errForbidden("the guarantee does not belong to the loan, NO: %s", loan.GuaranteeID)
+		return nil, res.With("id", loan.LoanID).With("guarantee_id", loan.GuaranteeID)
+	}
+
+	return gt, nil
+}
+
+// 按贷款金额和担保比例计算担保金额
+func (gt *Guarantee) cover(amount float64) {
+	gt.GuaranteedAmount = math.Round(amount*gt.CoveragePct) / 100
+}
+
+// 担保方担保贷款时生成担保合同，并与贷款关联
+func issueGuarantee(stub shim.ChaincodeStubInterface, loan *Loan, terms GuaranteeTermsArg) (*Guarantee, error) {
+	if terms.CoveragePct == 0 {
+		terms.CoveragePct = DefaultCoveragePct
+	}
+
+	if terms.CoveragePct < 0 || terms.CoveragePct > 100 {
+		return nil, newError(ErrInvalidArg, "coverage_pct should be between 0 and 100").With("coverage_pct", terms.CoveragePct)
+	}
+
+	if terms.Fee < 0 {
+		return nil, newError(ErrInvalidArg, "gt_fee should not be negative").With("gt_fee", terms.Fee)
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if terms.ValidUntil != 0 {
+		// 传入的到期时间须晚于当前时间和还款时间，否则担保无法被要求承担责任
+		if terms.ValidUntil <= now {
+			return nil, newError(ErrInvalidArg, "valid_until should be later than now").With("valid_until", terms.ValidUntil)
+		}
+
+		if terms.ValidUntil <= loan.RepaymentDate {
+			res := newError(ErrInvalidArg, "valid_until should be later than repayment_date")
+			return nil, res.With("valid_until", terms.ValidUntil).With("repayment_date", loan.RepaymentDate)
+		}
+	} else if loan.RepaymentDate > 0 {
+		// 未指定时为还款时间加宽限期，违约后在宽限期内可以要求担保方承担责任；没有还款时间时不限
+		cfg, err := loadConfig(stub)
+		if err != nil {
+			return nil, err
+		}
+
+		terms.ValidUntil = loan.RepaymentDate + cfg.guaranteeGrace()
+	}
+
+	gt := Guarantee{
+		LoanID:        loan.LoanID,
+		Guarantor:     loan.Guarantor,
+		GuarantorName: loan.GuarantorName,
+		CoveragePct:   terms.CoveragePct,
+		AmountUnit:    loan.AmountUnit,
+		Fee:           terms.Fee,
+		ValidFrom:     now,
+		ValidUntil:    terms.ValidUntil,
+		State:         GuaranteeActive,
+	}
+	gt.cover(loan.Amount)
+
+	err = NewGuaranteeRepo(stub).Put(&gt)
+	if err != nil {
+		return nil, err
+	}
+
+	loan.GuaranteeID = gt.LoanID
+	return &gt, nil
+}
+
+// 贷款审批时确定受益金融机构，按审批金额重新计算担保金额
+func bindGuarantee(stub shim.ChaincodeStubInterface, loan *Loan) error {
+	if loan.GuaranteeID == "" {
+		return nil
+	}
+
+	repo := NewGuaranteeRepo(stub)
+	gt, err := repo.GetByLoan(loan)
+	if err != nil {
+		return err
+	}
+
+	gt.Beneficiary = loan.Bank
+	gt.cover(loan.Amount)
+
+	return repo.Put(gt)
+}
+
+// 贷款结束时解除生效中的担保，已被要求承担责任的担保改为结清
+func releaseGuarantee(stub shim.ChaincodeStubInterface, loan *Loan) error {
+	if loan.GuaranteeID == "" {
+		return nil
+	}
+
+	repo := NewGuaranteeRepo(stub)
+	gt, err := repo.GetByLoan(loan)
+	if err != nil {
+		return err
+	}
+
+	switch gt.State {
+	case GuaranteeActive:
+		gt.State = GuaranteeReleased
+	case GuaranteeCalled:
+		gt.State = GuaranteeSettled
+	default:
+		return nil
+	}
+
+	return repo.Put(gt)
+}
+
+//callGuarantee 借款人到期未还款，金融机构要求担保方按担保金额承担还款责任：贷款的还款责任方改为担保方，
+// 担保未覆盖的本金仍由贷款人偿还，双方通过repayLoan累计还清本金后贷款结束
+//  args: 0 - Loan ID ; 1 - Bank Name
+func (sfb *SupplyFinance) callGuarantee(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	loan, err := NewLoanRepo(stub).Get(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if !loan.ValidateBankName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke callGuarantee failed: bank's name is not same with current's")
+	}
+
+	if !loan.ValidateState(LoanLoaned) {
+		return nil, errWrongState("loan", loan.State, LoanLoaned)
+	}
+
+	if loan.GuaranteeID == "" {
+		return nil, newError(ErrNotFound, "Chaincode Invoke callGuarantee failed: the loan has no guarantee").With("id", loan.LoanID)
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// 还款时间之后才算违约
+	if now <= loan.RepaymentDate {
+		return nil, newError(ErrWrongState, "Chaincode Invoke callGuarantee failed: the loan is not overdue").With("repayment_date", loan.RepaymentDate)
+	}
+
+	repo := NewGuaranteeRepo(stub)
+	gt, err := repo.GetByLoan(loan)
+	if err != nil {
+		return nil, err
+	}
+
+	if gt.State != GuaranteeActive {
+		return nil, errWrongState("guarantee", gt.State, GuaranteeActive)
+	}
+
+	if gt.ValidUntil > 0 && now > gt.ValidUntil {
+		return nil, newError(ErrExpired, "Chaincode Invoke callGuarantee failed: the guarantee is expired").With("id", gt.LoanID).With("valid_until", gt.ValidUntil)
+	}
+
+	gt.State = GuaranteeCalled
+	gt.CalledAmount = gt.GuaranteedAmount
+	gt.CallDate = now
+	err = repo.Put(gt)
+	if err != nil {
+		return nil, err
+	}
+
+	loan.Obligor = gt.Guarantor
+	loan.UncoveredAmount = math.Max(0, math.Round((loan.Amount-gt.CalledAmount)*100)/100)
+	err = setLoanStateThenPut(stub, loan, LoanLoaned, LoanCalled)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"loan": loan, "guarantee": gt}, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 申请担保贷款，担保方为还款人drawee
+func (s *testStub) applyGuarantee(loanID, billID, owner string, amount float64, repaymentDate int64) pb.Response {
+	fields := fmt.Sprintf(`,"guarantor":"drawee","guarantor_name":"draween","repayment_date":%d`, repaymentDate)
+	return s.invoke("applyGuarantee", loanArg(loanID, billID, owner, amount, fields))
+}
+
+// 担保方担保贷款，返回担保合同
+func (s *testStub) endorseLoan(loanID string, terms ...string) Guarantee {
+	var res struct {
+		Guarantee Guarantee `json:"guarantee"`
+	}
+	decodeData(s.t, mustOK(s.t, s.invoke("endorseLoan", append([]string{loanID, "draween"}, terms...)...)), &res)
+	return res.Guarantee
+}
+
+func TestCallGuaranteeWindow(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+	start := s.now
+	repaymentDate := start + 10*testDay
+
+	s.register("bank1", bank)
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.applyGuarantee("l1", "b1", "owner", 800, repaymentDate))
+
+	// 未指定到期时间时为还款时间加默认宽限期
+	gt := s.endorseLoan("l1")
+	if gt.ValidUntil != repaymentDate+DefaultGuaranteeGrace {
+		t.Fatalf("unexpected valid_until: %d", gt.ValidUntil)
+	}
+
+	s.acceptOffer(bank, owner, "l1", "owner", "bank1", 800)
+	mustOK(t, s.invoke("makeLoan", "l1", "bank1n", fmt.Sprint(start)))
+
+	// 还款时间之前不算违约
+	mustFail(t, s.invoke("callGuarantee", "l1", "bank1n"), ErrWrongState)
+
+	// 宽限期之后担保已到期
+	s.now = gt.ValidUntil + 1
+	mustFail(t, s.invoke("callGuarantee", "l1", "bank1n"), ErrExpired)
+
+	s.now = repaymentDate + testDay
+	var res struct {
+		Loan      Loan      `json:"loan"`
+		Guarantee Guarantee `json:"guarantee"`
+	}
+	decodeData(t, mustOK(t, s.invoke("callGuarantee", "l1", "bank1n")), &res)
+	if res.Loan.State != LoanCalled || res.Guarantee.State != GuaranteeCalled || res.Guarantee.CalledAmount != 800 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestGuaranteeValidUntil(t *testing.T) {
+	s := newTestStub(t)
+	admin := newIdentity(t, testAdminMSP, "admin", "")
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	repaymentDate := s.now + 10*testDay
+
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	s.as(owner).issueEndorsedBill("b2", "drawee", "owner", 1000)
+	s.as(owner).issueEndorsedBill("b3", "drawee", "owner", 1000)
+
+	// 没有还款时间时不传担保条件仍可担保，担保不限到期时间
+	mustOK(t, s.applyGuarantee("l1", "b1", "owner", 800, 0))
+	if gt := s.endorseLoan("l1"); gt.ValidUntil != 0 {
+		t.Fatalf("expect unlimited guarantee, got valid_until %d", gt.ValidUntil)
+	}
+
+	// 传入的到期时间须晚于当前时间和还款时间
+	mustOK(t, s.applyGuarantee("l2", "b2", "owner", 800, repaymentDate))
+	mustFail(t, s.invoke("endorseLoan", "l2", "draween", fmt.Sprintf(`{"valid_until":%d}`, s.now)), ErrInvalidArg)
+	mustFail(t, s.invoke("endorseLoan", "l2", "draween", fmt.Sprintf(`{"valid_until":%d}`, repaymentDate)), ErrInvalidArg)
+	if gt := s.endorseLoan("l2", fmt.Sprintf(`{"valid_until":%d}`, repaymentDate+testDay)); gt.ValidUntil != repaymentDate+testDay {
+		t.Fatalf("unexpected valid_until: %d", gt.ValidUntil)
+	}
+
+	// 宽限期取配置
+	cfg := `{"admin_msps":["` + testAdminMSP + `"],"split_defaults":{"max_split_depth":1,"amount_precision":2},"day_count":"ACT/360","transfer_offer_ttl":604800000,"guarantee_grace_period":%d}`
+	mustFail(t, s.as(admin).invoke("updateConfig", fmt.Sprintf(cfg, -1)), ErrInvalidArg)
+	mustOK(t, s.as(admin).invoke("updateConfig", fmt.Sprintf(cfg, testDay)))
+	mustOK(t, s.as(owner).applyGuarantee("l3", "b3", "owner", 800, repaymentDate))
+	if gt := s.endorseLoan("l3"); gt.ValidUntil != repaymentDate+testDay {
+		t.Fatalf("unexpected valid_until: %d", gt.ValidUntil)
+	}
+}
+
+func TestGuaranteeBelongsToLoan(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.applyGuarantee("l1", "b1", "owner", 800, s.now+10*testDay))
+	s.endorseLoan("l1")
+
+	// 担保合同编号由链码维护，不能在申请时指定其他贷款的担保
+	s.as(owner).issueEndorsedBill("b2", "drawee", "owner", 1000)
+	mustFail(t, s.applyLoan("l2", "b2", "owner", 800, `,"guarantee_id":"l1"`), ErrInvalidArg)
+
+	// 账本中关联了其他贷款担保的记录不能解除该担保
+	mustOK(t, s.applyLoan("l2", "b2", "owner", 800, ""))
+	var loan Loan
+	s.getRecord(loanTable, "l2", &loan)
+	loan.GuaranteeID = "l1"
+	s.putRaw(loanTable, "l2", loan)
+	mustFail(t, s.invoke("withdrawLoan", "l2", "ownern"), ErrForbidden)
+
+	var gt Guarantee
+	s.getRecord(guaranteeTable, "l1", &gt)
+	if gt.State != GuaranteeActive {
+		t.Fatalf("the guarantee of l1 should stay active, got %s", gt.State)
+	}
+}
+
+func TestCalledGuaranteeRepayment(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+	start := s.now
+	repaymentDate := start + 10*testDay
+
+	s.register("bank1", bank)
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.applyGuarantee("l1", "b1", "owner", 800, repaymentDate))
+	s.endorseLoan("l1", `{"coverage_pct":75}`)
+	s.acceptOffer(bank, owner, "l1", "owner", "bank1", 800)
+	mustOK(t, s.invoke("makeLoan", "l1", "bank1n", fmt.Sprint(start)))
+
+	// 担保方承担600，贷款人仍须偿还未覆盖的200
+	s.now = repaymentDate + testDay
+	var res struct {
+		Loan Loan `json:"loan"`
+	}
+	decodeData(t, mustOK(t, s.invoke("callGuarantee", "l1", "bank1n")), &res)
+	if res.Loan.Obligor != "drawee" || res.Loan.UncoveredAmount != 200 {
+		t.Fatalf("unexpected loan: %+v", res.Loan)
+	}
+
+	repay := func(amount float64) chaincodeRet {
+		arg := fmt.Sprintf(`{"lr_loan_id":"l1","lr_bank_name":"bank1n","actual_repayment_date":%d,"actual_ln_amount":%v,"actual_bank_interest":1}`, s.now, amount)
+		return mustOK(t, s.invoke("repayLoan", arg))
+	}
+
+	// 担保方还款后贷款和担保仍未结束
+	var loan Loan
+	ret := repay(600)
+	decodeData(t, ret, &loan)
+	if loan.State != LoanCalled || ret.Description != "invoke repayLoan success, waiting for the remaining repayment" {
+		t.Fatalf("unexpected loan state after guarantor's repayment: %s, %s", loan.State, ret.Description)
+	}
+
+	var gt Guarantee
+	s.getRecord(guaranteeTable, "l1", &gt)
+	if gt.State != GuaranteeCalled {
+		t.Fatalf("unexpected guarantee state: %s", gt.State)
+	}
+
+	// 贷款人还清未覆盖部分后贷款结束，担保结清
+	decodeData(t, repay(200), &loan)
+	s.getRecord(guaranteeTable, "l1", &gt)
+	if loan.State != LoanRepaid || gt.State != GuaranteeSettled {
+		t.Fatalf("unexpected states: loan %s, guarantee %s", loan.State, gt.State)
+	}
+
+	var bill Bill
+	s.getRecord(billTable, "b1", &bill)
+	if bill.State != BillRedeemed {
+		t.Fatalf("unexpected bill state: %s", bill.State)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//indexDef 二级索引定义，索引key为 Name + 属性值 + 记录ID 组成的复合键
+type indexDef struct {
+	Name  string // 索引名，即复合键的objectType
+	Field string // 记录中作为索引属性的JSON字段
+}
+
+// 票据、贷款的二级索引，不依赖CouchDB富查询，LevelDB同样可用
+var (
+	billIndexes = []indexDef{
+		{"owner~bill", "owner"},       // 持票人 -> 票据
+		{"drawee~bill", "drawee"},     // 还款人 -> 票据
+		{"parent~child", "parent_id"}, // 父票据或合同 -> 子票据
+	}
+	loanIndexes = []indexDef{
+		{"bank~loan", "ln_bank"},        // 金融机构 -> 贷款
+		{"guarantor~loan", "guarantor"}, // 担保方 -> 贷款
+		{"bill~loan", "ln_bill_id"},     // 票据 -> 贷款
+	}
+)
+
+// 流转事件索引，在流转生效时写入，不随记录字段变化
+const (
+	TransferredIndex = "transferred~bill" // 原持有人 -> 流转出去的票据
+	ReceivedIndex    = "received~bill"    // 新持有人 -> 接收的票据
+)
+
+// 索引名 -> 所属表，由registerTables填充
+var SF_INDEXES = map[string]tableDef{}
+
+func init() {
+	// 流转事件索引的记录为票据，可通过queryByIndex查询
+	SF_INDEXES[TransferredIndex] = billTable
+	SF_INDEXES[ReceivedIndex] = billTable
+}
+
+// 从记录JSON中取索引属性值，记录不存在或字段为空时返回空串
+func indexValue(obj_bytes []byte, field string) (string, error) {
+	if obj_bytes == nil {
+		return "", nil
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(obj_bytes, &doc); err != nil {
+		return "", err
+	}
+
+	v, _ := doc[field].(string)
+	return v, nil
+}
+
+// 记录变更时同步更新索引：属性值变化时删除旧索引项，写入新索引项
+func updateIndexes(stub shim.ChaincodeStubInterface, table tableDef, id string, old_bytes, new_bytes []byte) error {
+	for _, idx := range table.Indexes {
+		oldV, err := indexValue(old_bytes, idx.Field)
+		if err != nil {
+			return errCorrupt(table.Name, id, err.Error())
+		}
+
+		newV, err := indexValue(new_bytes, idx.Field)
+		if err != nil {
+			return wrapError(err)
+		}
+
+		if oldV == newV {
+			continue
+		}
+
+		if oldV != "" {
+			key, err := stub.CreateCompositeKey(idx.Name, []string{oldV, id})
+			if err != nil {
+				return wrapError(err)
+			}
+
+			if err = stub.DelState(key); err != nil {
+				return wrapError(err)
+			}
+		}
+
+		if newV != "" {
+			key, err := stub.CreateCompositeKey(idx.Name, []string{newV, id})
+			if err != nil {
+				return wrapError(err)
+			}
+
+			// 索引项只需要key，value不能为空，存一个空字节
+			if err = stub.PutState(key, []byte{0x00}); err != nil {
+				return wrapError(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// 写入一条索引项，索引项只需要key
+func putIndexEntry(stub shim.ChaincodeStubInterface, index string, attrs ...string) error {
+	key, err := stub.CreateCompositeKey(index, attrs)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err = stub.PutState(key, []byte{0x00}); err != nil {
+		return wrapError(err)
+	}
+
+	return nil
+}
+
+// 记录票据流转的索引项，每次流转写入独立的key，避免同一持有人的并发交易冲突
+func putTransferIndexes(stub shim.ChaincodeStubInterface, ti TransferInfoArg) error {
+	if err := putIndexEntry(stub, TransferredIndex, ti.OldOwner, ti.BillID); err != nil {
+		return err
+	}
+
+	return putIndexEntry(stub, ReceivedIndex, ti.NewOwner, ti.BillID)
+}
+
+//PageMetadata 分页查询信息
+type PageMetadata struct {
+	RecordsCount int32  // 本页记录条数
+	Bookmark     string // 下一页的分页标签
+}
+
+//QueryRecord 查询结果中的一条记录
+type QueryRecord struct {
+	Key    string          // 记录ID
+	Record json.RawMessage // 记录内容
+}
+
+//PageResult 分页查询结果
+type PageResult struct {
+	ResponseMetadata PageMetadata
+	Records          []QueryRecord
+}
+
+// 按索引分页查询记录ID
+func queryIndexIDs(stub shim.ChaincodeStubInterface, index string, attrs []string, pageSize int32, bookmark string) ([]string, *pb.QueryResponseMetadata, error) {
+	resultsIterator, meta, err := stub.GetStateByPartialCompositeKeyWithPagination(index, attrs, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, wrapError(err)
+	}
+	defer resultsIterator.Close()
+
+	ids := make([]string, 0)
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, wrapError(err)
+		}
+
+		_, keyParts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, nil, wrapError(err)
+		}
+
+		if len(keyParts) > 0 {
+			ids = append(ids, keyParts[len(keyParts)-1])
+		}
+	}
+
+	return ids, meta, nil
+}
+
+// 按索引查询全部记录ID
+func queryAllIndexIDs(stub shim.ChaincodeStubInterface, index string, attrs []string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(index, attrs)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	defer resultsIterator.Close()
+
+	ids := make([]string, 0)
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		_, keyParts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		if len(keyParts) > 0 {
+			ids = append(ids, keyParts[len(keyParts)-1])
+		}
+	}
+
+	return ids, nil
+}
+
+//queryByIndex 按二级索引分页查询记录，LevelDB和CouchDB均可用
+//  0 - Index Name(owner~bill|drawee~bill|parent~child|transferred~bill|received~bill|bank~loan|guarantor~loan|bill~loan) ; 1 - Attribute Value ; 2 - count of page ; 3 - pagination bookmark
+func (sfb *SupplyFinance) queryByIndex(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	table, exist := SF_INDEXES[args.String(0)]
+	if !exist {
+		return nil, newError(ErrInvalidArg, "the index[%s] is not exist", args.String(0)).With("index", args.String(0))
+	}
+
+	pageSize := args.Int(2)
+	if pageSize > math.MaxInt32 {
+		return nil, newError(ErrInvalidArg, "page size should be int32: %s", args.String(2)).With("arg", args.String(2))
+	}
+
+	ids, meta, err := queryIndexIDs(stub, args.String(0), []string{args.String(1)}, int32(pageSize), args.String(3))
+	if err != nil {
+		return nil, err
+	}
+
+	store := newStore(stub, table)
+	result := PageResult{ResponseMetadata: PageMetadata{RecordsCount: meta.FetchedRecordsCount, Bookmark: meta.Bookmark}}
+	result.Records = make([]QueryRecord, 0, len(ids))
+	for _, id := range ids {
+		obj_bytes, err := store.GetBytes(id)
+		if err != nil {
+			return nil, err
+		}
+
+		// 索引项对应的记录已不存在时跳过
+		if obj_bytes == nil {
+			continue
+		}
+
+		result.Records = append(result.Records, QueryRecord{Key: id, Record: json.RawMessage(obj_bytes)})
+	}
+
+	return result, nil
+}
+
+// 重建索引时每页最多处理的记录数
+const MaxRebuildPageSize = 1000
+
+//RebuildResult 重建索引的结果
+type RebuildResult struct {
+	Table    string `json:"table"`    //表名
+	Rebuilt  int    `json:"rebuilt"`  //本页补写索引的记录数
+	Bookmark string `json:"bookmark"` //下一页的起始记录ID，为空时已处理完
+}
+
+// 表前缀之后的第一个key，作为表记录范围查询的结束key(不含)
+func prefixEnd(prefix string) string {
+	return prefix[:len(prefix)-1] + string(prefix[len(prefix)-1]+1)
+}
+
+//rebuildIndexes 管理员为升级前保存、没有二级索引的记录补写索引，按记录ID顺序从bookmark开始处理一页，返回下一页的bookmark；管理员由路由检查
+//  args: 0 - Table Name ; 1 - count of page ; 2 - bookmark，可选，第一页不传
+func (sfb *SupplyFinance) rebuildIndexes(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	table, err := lookupTable(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(table.Indexes) == 0 {
+		return nil, newError(ErrInvalidArg, "the table[%s] has no index", table.Name).With("table", table.Name)
+	}
+
+	pageSize := args.Int(1)
+	if pageSize < 1 || pageSize > MaxRebuildPageSize {
+		return nil, newError(ErrInvalidArg, "page size should be between 1 and %d: %s", MaxRebuildPageSize, args.String(1)).With("arg", args.String(1))
+	}
+
+	bookmark := ""
+	if args.Len() == 3 {
+		bookmark = args.String(2)
+	}
+
+	// 分页查询只能在只读交易中使用，这里按key范围读取，多读的一条作为下一页的起始
+	resultsIterator, err := stub.GetStateByRange(table.Prefix+bookmark, prefixEnd(table.Prefix))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	defer resultsIterator.Close()
+
+	result := RebuildResult{Table: table.Name}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		id := strings.TrimPrefix(kv.Key, table.Prefix)
+		if result.Rebuilt == int(pageSize) {
+			result.Bookmark = id
+			break
+		}
+
+		// 按没有旧记录写入全部索引项，已有的索引项重复写入不影响
+		err = updateIndexes(stub, table, id, nil, kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		result.Rebuilt++
+	}
+
+	return result, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRebuildIndexes(t *testing.T) {
+	s := newTestStub(t)
+	admin := newIdentity(t, testAdminMSP, "admin", "")
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+
+	// 升级前保存的记录，账本中没有索引项
+	bill := testBill("b1", "drawee", "owner", 1000)
+	bill.State = BillLoanReady
+	s.putRaw(billTable, "b1", bill)
+	s.putRaw(loanTable, "l1", Loan{LoanID: "l1", BillID: "b1", Amount: 500, AmountUnit: "yuan", Owner: "owner", OwnerName: "ownern", State: LoanApplied})
+	s.putRaw(loanTable, "l2", Loan{LoanID: "l2", BillID: "b1", Amount: 500, AmountUnit: "yuan", Owner: "owner", OwnerName: "ownern", State: LoanApplied})
+
+	key, _ := s.CreateCompositeKey("bill~loan", []string{"b1", "l1"})
+	if s.State[key] != nil {
+		t.Fatal("legacy loan should have no index entry")
+	}
+
+	// 只有管理员可以补写索引
+	mustFail(t, s.as(owner).invoke("rebuildIndexes", "loan", "1"), ErrForbidden)
+	mustFail(t, s.as(admin).invoke("rebuildIndexes", "contract", "1"), ErrInvalidArg)
+	mustFail(t, s.as(admin).invoke("rebuildIndexes", "loan", "0"), ErrInvalidArg)
+
+	var rr RebuildResult
+	decodeData(t, mustOK(t, s.as(admin).invoke("rebuildIndexes", "loan", "1")), &rr)
+	if rr.Rebuilt != 1 || rr.Bookmark != "l2" {
+		t.Fatalf("unexpected first page: %+v", rr)
+	}
+
+	decodeData(t, mustOK(t, s.as(admin).invoke("rebuildIndexes", "loan", "1", rr.Bookmark)), &rr)
+	if rr.Rebuilt != 1 || rr.Bookmark != "" {
+		t.Fatalf("unexpected last page: %+v", rr)
+	}
+
+	for _, id := range []string{"l1", "l2"} {
+		key, _ = s.CreateCompositeKey("bill~loan", []string{"b1", id})
+		if s.State[key] == nil {
+			t.Fatalf("index entry of loan %s is not rebuilt", id)
+		}
+	}
+
+	// 补写索引后作废票据会拒绝旧的贷款申请
+	var res struct {
+		RejectedLoans []Loan `json:"rejected_loans"`
+	}
+	decodeData(t, mustOK(t, s.as(owner).invoke("abolishBill", "b1", "ownern")), &res)
+	if len(res.RejectedLoans) != 2 {
+		t.Fatalf("expect 2 rejected loans, got %d", len(res.RejectedLoans))
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// 一天的毫秒数
+const DayMillis int64 = 24 * 3600 * THOUSAND
+
+// 按计息天数惯例计算本金从from到to(毫秒)的利息，rate为年利率(%)，结果保留2位小数
+func interest(dayCount string, principal, rate float64, from, to int64) float64 {
+	v := principal * rate / 100 * yearFraction(dayCount, from, to)
+	return math.Round(v*100) / 100
+}
+
+// 计息期限占一年的比例，按UTC日期计算天数
+func yearFraction(dayCount string, from, to int64) float64 {
+	if to <= from {
+		return 0
+	}
+
+	switch dayCount {
+	case DayCount30360:
+		return float64(days30360(from, to)) / 360
+	case DayCountAct365:
+		return float64(actualDays(from, to)) / 365
+	default:
+		return float64(actualDays(from, to)) / 360
+	}
+}
+
+// 实际天数
+func actualDays(from, to int64) int64 {
+	return to/DayMillis - from/DayMillis
+}
+
+// 30/360天数：每月按30天计，月末31日按30日计
+func days30360(from, to int64) int64 {
+	y1, m1, d1 := time.Unix(from/THOUSAND, 0).UTC().Date()
+	y2, m2, d2 := time.Unix(to/THOUSAND, 0).UTC().Date()
+	if d1 == 31 {
+		d1 = 30
+	}
+	if d2 == 31 && d1 == 30 {
+		d2 = 30
+	}
+
+	return int64(360*(y2-y1) + 30*(int(m2)-int(m1)) + (d2 - d1))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestYearFraction(t *testing.T) {
+	millis := func(y int, m time.Month, d int) int64 {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() * THOUSAND
+	}
+
+	from, to := millis(2020, time.January, 31), millis(2020, time.March, 31)
+	cases := []struct {
+		dayCount string
+		expect   float64
+	}{
+		{DayCountAct360, 60.0 / 360},
+		{DayCountAct365, 60.0 / 365},
+		{DayCount30360, 60.0 / 360},
+	}
+	for _, c := range cases {
+		if got := yearFraction(c.dayCount, from, to); got != c.expect {
+			t.Fatalf("%s: expect %v, got %v", c.dayCount, c.expect, got)
+		}
+	}
+
+	// 2月末不调整，30/360按月计30天
+	if got := days30360(millis(2020, time.February, 29), millis(2020, time.March, 31)); got != 32 {
+		t.Fatalf("expect 32 days, got %d", got)
+	}
+
+	if got := interest(DayCountAct360, 1000, 3.6, from, to); got != 6 {
+		t.Fatalf("expect interest 6, got %v", got)
+	}
+}
+
+func TestRepayLoanInterestByDayCount(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+	start := s.now
+
+	s.register("bank1", bank)
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.as(owner).applyLoan("l1", "b1", "owner", 720, ""))
+	s.acceptOffer(bank, owner, "l1", "owner", "bank1", 720)
+	mustOK(t, s.as(bank).invoke("makeLoan", "l1", "bank1n", fmt.Sprint(start)))
+
+	// 未传入利息时按配置的ACT/360计算：720 * 0.05% * 360/360
+	repay := fmt.Sprintf(`{"lr_loan_id":"l1","lr_bank_name":"bank1n","actual_repayment_date":%d,"actual_ln_amount":720}`, start+360*DayMillis)
+	mustOK(t, s.as(owner).invoke("repayLoan", repay))
+
+	var lr LoanRepayment
+	s.getRecord(loanRepaymentTable, "l1", &lr)
+	if lr.DayCount != DayCountAct360 || lr.ActualBankRate != 0.05 || lr.ActualBankInterest != 0.36 {
+		t.Fatalf("unexpected repayment: %+v", lr)
+	}
+}
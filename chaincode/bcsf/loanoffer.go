@@ -0,0 +1,312 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var loanOfferTable = tableDef{"loan_offer", "LNOF_", loanOfferIndexes}
+
+var loanOfferIndexes = []indexDef{
+	{"loan~offer", "lo_loan_id"}, // 贷款 -> 报价
+}
+
+func init() {
+	registerTables(loanOfferTable)
+}
+
+//LoanOffer 金融机构对贷款申请的报价，每个金融机构对一个贷款申请只有一个报价，重复提交时覆盖
+type LoanOffer struct {
+	LoanID       string  `json:"lo_loan_id"`                         //贷款编号
+	Bank         string  `json:"lo_bank" sf:"excludes=_"`            //金融机构系统账号，不能包含报价ID的分隔符"_"
+	BankName     string  `json:"lo_bank_name"`                       //金融机构名称
+	Amount       float64 `json:"offer_amount"`                       //报价贷款金额，不超过申请金额
+	BankRate     float64 `json:"offer_rate"`                         //报价贷款利率
+	BankInterest float64 `json:"offer_interest"`                     //报价贷款利息
+	ExpireDate   int64   `json:"offer_expire_date"`                  //报价有效期，毫秒
+	State        string  `json:"offer_state" sf:"readonly"`          //报价状态，由链码维护
+	SubmitDate   int64   `json:"offer_date" sf:"readonly"`           //提交报价的交易时间
+	CloseDate    int64   `json:"close_date,omitempty" sf:"readonly"` //接受或拒绝报价的时间
+}
+
+// 报价ID：贷款编号_金融机构系统账号，金融机构账号不含"_"，保证不同报价的ID不重复
+func loanOfferID(loanID, bank string) string {
+	return loanID + "_" + bank
+}
+
+func (lo LoanOffer) recordID() string {
+	return loanOfferID(lo.LoanID, lo.Bank)
+}
+
+func (lo LoanOffer) validate() error {
+	if lo.State == "" {
+		return newError(ErrInvalidArg, "the state of loan offer should not be empty").With("id", lo.recordID())
+	}
+
+	return nil
+}
+
+// 报价是否已过期
+func (lo LoanOffer) expired(now int64) bool {
+	return now > lo.ExpireDate
+}
+
+//LoanOfferRepo 贷款报价表
+type LoanOfferRepo struct {
+	Store
+}
+
+func NewLoanOfferRepo(stub shim.ChaincodeStubInterface) LoanOfferRepo {
+	return LoanOfferRepo{newStore(stub, loanOfferTable)}
+}
+
+func (r LoanOfferRepo) Get(loanID, bank string) (*LoanOffer, error) {
+	var lo LoanOffer
+	if err := r.Store.Get(loanOfferID(loanID, bank), &lo); err != nil {
+		return nil, err
+	}
+
+	return &lo, nil
+}
+
+//Find 报价不存在时返回nil
+func (r LoanOfferRepo) Find(loanID, bank string) (*LoanOffer, error) {
+	var lo LoanOffer
+	found, err := r.Store.Find(loanOfferID(loanID, bank), &lo)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &lo, nil
+}
+
+func (r LoanOfferRepo) Put(lo *LoanOffer) error {
+	return r.Store.Put(lo.recordID(), *lo)
+}
+
+// 取得贷款申请的全部报价
+func loanOffersOf(stub shim.ChaincodeStubInterface, loanID string) ([]*LoanOffer, error) {
+	ids, err := queryAllIndexIDs(stub, "loan~offer", []string{loanID})
+	if err != nil {
+		return nil, err
+	}
+
+	store := newStore(stub, loanOfferTable)
+	offers := make([]*LoanOffer, 0, len(ids))
+	for _, id := range ids {
+		var lo LoanOffer
+		if err := store.Get(id, &lo); err != nil {
+			return nil, err
+		}
+
+		offers = append(offers, &lo)
+	}
+
+	return offers, nil
+}
+
+// 取得金融机构待接受且未过期的报价
+func getPendingLoanOffer(stub shim.ChaincodeStubInterface, loanID, bank string) (*LoanOffer, error) {
+	lo, err := NewLoanOfferRepo(stub).Get(loanID, bank)
+	if err != nil {
+		return nil, err
+	}
+
+	if lo.State != OfferPending {
+		return nil, errWrongState("loan_offer", lo.State, OfferPending)
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if lo.expired(now) {
+		return nil, newError(ErrExpired, "the loan offer is expired, NO: %s", lo.recordID()).With("id", lo.recordID()).With("expire_date", lo.ExpireDate)
+	}
+
+	return lo, nil
+}
+
+// 关闭贷款申请上其他待接受的报价：accepted_bank之外的报价改为拒绝，已过期的记录为过期
+func closeLoanOffers(stub shim.ChaincodeStubInterface, loanID, accepted_bank string) ([]*LoanOffer, error) {
+	repo := NewLoanOfferRepo(stub)
+	offers, err := loanOffersOf(stub, loanID)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	closed := make([]*LoanOffer, 0)
+	for _, lo := range offers {
+		if lo.State != OfferPending || lo.Bank == accepted_bank {
+			continue
+		}
+
+		lo.State = OfferDeclined
+		if lo.expired(now) {
+			lo.State = OfferExpired
+		}
+		lo.CloseDate = now
+
+		if err = repo.Put(lo); err != nil {
+			return nil, err
+		}
+		closed = append(closed, lo)
+	}
+
+	return closed, nil
+}
+
+// 金融机构拒绝贷款时拒绝自己待接受的报价，返回其他金融机构是否还有有效报价
+func refuseLoanOffer(stub shim.ChaincodeStubInterface, loanID, bank string) (bool, error) {
+	offers, err := loanOffersOf(stub, loanID)
+	if err != nil {
+		return false, err
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return false, err
+	}
+
+	waiting := false
+	for _, lo := range offers {
+		if lo.State != OfferPending {
+			continue
+		}
+
+		if lo.Bank != bank {
+			waiting = waiting || !lo.expired(now)
+			continue
+		}
+
+		lo.State = OfferDeclined
+		lo.CloseDate = now
+		if err = NewLoanOfferRepo(stub).Put(lo); err != nil {
+			return false, err
+		}
+	}
+
+	return waiting, nil
+}
+
+// 接受报价：按报价设置贷款金融机构、金额和利率，贷款审批通过，票据抵押，其他报价自动拒绝
+func acceptLoanOfferObj(stub shim.ChaincodeStubInterface, loan *Loan, lo *LoanOffer) ([]*LoanOffer, error) {
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	loan.Bank = lo.Bank
+	loan.BankName = lo.BankName
+	loan.Amount = lo.Amount
+	loan.BankRate = lo.BankRate
+	loan.BankInterest = lo.BankInterest
+
+	err = approveLoanObj(stub, loan)
+	if err != nil {
+		return nil, err
+	}
+
+	lo.State = OfferAccepted
+	lo.CloseDate = now
+	err = NewLoanOfferRepo(stub).Put(lo)
+	if err != nil {
+		return nil, err
+	}
+
+	return closeLoanOffers(stub, loan.LoanID, lo.Bank)
+}
+
+//submitLoanOffer 金融机构对等待审批的贷款申请报价，报价有效期内可重复提交覆盖；金融机构须登记在调用者组织下
+//  args: 0 - {LoanOffer object}
+func (sfb *SupplyFinance) submitLoanOffer(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	lo := *args.Object(0).(*LoanOffer)
+
+	loan, err := NewLoanRepo(stub).Get(lo.LoanID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !loan.ValidateState(LoanApplied) {
+		return nil, errWrongState("loan", loan.State, LoanApplied)
+	}
+
+	if lo.Bank == "" || lo.BankName == "" {
+		return nil, newError(ErrInvalidArg, "Chaincode Invoke submitLoanOffer failed: the bank of offer should not be empty")
+	}
+
+	// 只能以调用者组织登记的金融机构报价
+	err = requireParticipant(stub, lo.Bank)
+	if err != nil {
+		return nil, err
+	}
+
+	if lo.Amount <= 0 || lo.Amount > loan.Amount+AmountEpsilon {
+		res := newError(ErrInvalidArg, "Chaincode Invoke submitLoanOffer failed: the offer amount should be greater than 0 and not more than the applied amount")
+		return nil, res.With("offer_amount", lo.Amount).With("ln_amount", loan.Amount)
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if lo.expired(now) {
+		return nil, newError(ErrInvalidArg, "Chaincode Invoke submitLoanOffer failed: the offer expire date should be later than now").With("offer_expire_date", lo.ExpireDate)
+	}
+
+	// 已接受或拒绝的报价不能再修改
+	old, err := NewLoanOfferRepo(stub).Find(lo.LoanID, lo.Bank)
+	if err != nil {
+		return nil, err
+	}
+
+	if old != nil && old.State != OfferPending {
+		return nil, errWrongState("loan_offer", old.State, OfferPending)
+	}
+
+	lo.State = OfferPending
+	lo.SubmitDate = now
+	err = NewLoanOfferRepo(stub).Put(&lo)
+	if err != nil {
+		return nil, err
+	}
+
+	return lo, nil
+}
+
+//acceptLoanOffer 贷款人接受一个金融机构的报价，其他报价自动拒绝，票据抵押给该金融机构；贷款人须登记在调用者组织下，金融机构不能代为接受
+//  args: 0 - Loan ID ; 1 - Owner Name ; 2 - Bank
+func (sfb *SupplyFinance) acceptLoanOffer(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	loan, err := NewLoanRepo(stub).Get(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if !loan.ValidateOwnerName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke acceptLoanOffer failed: owner's name is not same with current's")
+	}
+
+	// 只有贷款人能接受报价
+	err = requireParticipant(stub, loan.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	lo, err := getPendingLoanOffer(stub, loan.LoanID, args.String(2))
+	if err != nil {
+		return nil, err
+	}
+
+	declined, err := acceptLoanOfferObj(stub, loan, lo)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"loan": loan, "offer": lo, "declined_offers": declined}, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLoanOfferRequiresRegisteredBank(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+	other := newIdentity(t, "OtherMSP", "other", RoleBank)
+	noRole := newIdentity(t, "BankMSP", "clerk", "")
+
+	s.register("bank1", bank)
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.as(owner).applyLoan("l1", "b1", "owner", 800, ""))
+
+	// 没有金融机构角色
+	mustFail(t, s.as(noRole).invoke("submitLoanOffer", s.offer("l1", "bank1", 800)), ErrForbidden)
+	// 以其他组织登记的金融机构报价
+	mustFail(t, s.as(other).invoke("submitLoanOffer", s.offer("l1", "bank1", 800)), ErrForbidden)
+	// 金融机构未登记
+	mustFail(t, s.as(bank).invoke("submitLoanOffer", s.offer("l1", "bank2", 800)), ErrForbidden)
+
+	mustOK(t, s.as(bank).invoke("submitLoanOffer", s.offer("l1", "bank1", 800)))
+
+	mustFail(t, s.as(other).invoke("refuseLoan", loanResult("l1", "owner", "bank1")), ErrForbidden)
+}
+
+func TestOnlyOwnerAcceptsLoanOffer(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+	bank2 := newIdentity(t, "Bank2MSP", "bank2", RoleBank)
+
+	s.register("bank1", bank)
+	s.register("bank2", bank2)
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.as(owner).applyLoan("l1", "b1", "owner", 800, ""))
+	mustOK(t, s.as(bank).invoke("submitLoanOffer", s.offer("l1", "bank1", 800)))
+	mustOK(t, s.as(bank2).invoke("submitLoanOffer", s.offer("l1", "bank2", 700)))
+
+	// 金融机构不能代贷款人接受自己的报价
+	mustFail(t, s.as(bank).invoke("approveLoan", loanResult("l1", "owner", "bank1")), ErrUnknownMethod)
+	mustFail(t, s.as(bank).invoke("acceptLoanOffer", "l1", "ownern", "bank1"), ErrForbidden)
+	// 贷款人未登记
+	mustFail(t, s.as(owner).invoke("acceptLoanOffer", "l1", "ownern", "bank1"), ErrForbidden)
+
+	s.register("owner", owner)
+	var res struct {
+		Loan     Loan        `json:"loan"`
+		Declined []LoanOffer `json:"declined_offers"`
+	}
+	decodeData(t, mustOK(t, s.as(owner).invoke("acceptLoanOffer", "l1", "ownern", "bank1")), &res)
+	if res.Loan.State != LoanApproved || res.Loan.Bank != "bank1" || len(res.Declined) != 1 || res.Declined[0].Bank != "bank2" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestRefuseLoanByRegisteredBank(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+
+	s.register("bank1", bank)
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.as(owner).applyLoan("l1", "b1", "owner", 800, ""))
+	mustOK(t, s.as(bank).invoke("submitLoanOffer", s.offer("l1", "bank1", 800)))
+
+	var loan Loan
+	decodeData(t, mustOK(t, s.as(bank).invoke("refuseLoan", loanResult("l1", "owner", "bank1"))), &loan)
+	if loan.State != LoanRefused {
+		t.Fatalf("unexpected loan state: %s", loan.State)
+	}
+}
+
+func TestLoanOfferStateIsReadonly(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+
+	s.register("bank1", bank)
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.as(owner).applyLoan("l1", "b1", "owner", 800, ""))
+
+	// 报价状态和时间由链码维护
+	offer := s.offer("l1", "bank1", 800)
+	for _, field := range []string{`"offer_state":"accepted"`, `"offer_date":1`, `"close_date":1`} {
+		mustFail(t, s.as(bank).invoke("submitLoanOffer", offer[:len(offer)-1]+","+field+"}"), ErrInvalidArg)
+	}
+
+	var lo LoanOffer
+	decodeData(t, mustOK(t, s.as(bank).invoke("submitLoanOffer", offer)), &lo)
+	if lo.State != OfferPending || lo.SubmitDate != s.now {
+		t.Fatalf("unexpected offer: %+v", lo)
+	}
+}
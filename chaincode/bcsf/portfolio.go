@@ -0,0 +1,108 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//Portfolio 企业的票据资产视图，金额按金额单位分别汇总
+type Portfolio struct {
+	Owner             string             `json:"owner"`              //企业系统账号
+	Holdings          []Bill             `json:"holdings"`           //当前持有的票据，不含已抵押的票据
+	HoldingAmount     map[string]float64 `json:"holding_amount"`     //持有票据金额合计
+	Pledged           []Bill             `json:"pledged"`            //已抵押给金融机构的票据
+	PledgedAmount     map[string]float64 `json:"pledged_amount"`     //抵押票据金额合计
+	Transferred       []Bill             `json:"transferred"`        //流转出去且当前不再持有的票据
+	TransferredAmount map[string]float64 `json:"transferred_amount"` //流转出去票据金额合计
+}
+
+// 持有票据的状态，已拆分、作废、赎回的票据不再计入资产
+var holdingStates = map[string]bool{
+	BillIssued:       true,
+	Endorsed:         true,
+	BillLoanReady:    true,
+	BillTransferring: true,
+	BillAbolishing:   true,
+}
+
+func newPortfolio(owner string) *Portfolio {
+	return &Portfolio{
+		Owner:             owner,
+		Holdings:          []Bill{},
+		HoldingAmount:     map[string]float64{},
+		Pledged:           []Bill{},
+		PledgedAmount:     map[string]float64{},
+		Transferred:       []Bill{},
+		TransferredAmount: map[string]float64{},
+	}
+}
+
+// 取得企业流转出去的票据号，包含升级前transferred_bill表中的记录
+func transferredBillIDs(stub shim.ChaincodeStubInterface, owner string) ([]string, error) {
+	ids, err := queryAllIndexIDs(stub, TransferredIndex, []string{owner})
+	if err != nil {
+		return nil, err
+	}
+
+	tb, err := NewTransferredBillRepo(stub).Find(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ids, tb.Bills...), nil
+}
+
+//queryPortfolio 查询企业当前持有、已抵押及流转出去的票据和金额合计
+//  args: 0 - Owner
+func (sfb *SupplyFinance) queryPortfolio(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	owner := args.String(0)
+	pf := newPortfolio(owner)
+	repo := NewBillRepo(stub)
+
+	ids, err := queryAllIndexIDs(stub, "owner~bill", []string{owner})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		bill, err := repo.Find(id)
+		if err != nil {
+			return nil, err
+		} else if bill == nil || !bill.ValidateOwner(owner) {
+			continue
+		}
+
+		if bill.ValidateState(BillMorgaged) {
+			pf.Pledged = append(pf.Pledged, *bill)
+			pf.PledgedAmount[bill.AmountUnit] += bill.Amount
+		} else if holdingStates[bill.State] {
+			pf.Holdings = append(pf.Holdings, *bill)
+			pf.HoldingAmount[bill.AmountUnit] += bill.Amount
+		}
+	}
+
+	ids, err = transferredBillIDs(stub, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		bill, err := repo.Find(id)
+		if err != nil {
+			return nil, err
+		} else if bill == nil || bill.ValidateOwner(owner) {
+			// 流转后又流转回来的票据计入持有
+			continue
+		}
+
+		pf.Transferred = append(pf.Transferred, *bill)
+		pf.TransferredAmount[bill.AmountUnit] += bill.Amount
+	}
+
+	return pf, nil
+}
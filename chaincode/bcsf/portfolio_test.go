@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQueryPortfolio(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+
+	s.register("bank1", bank)
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	s.issueEndorsedBill("b2", "drawee", "owner", 2000)
+	s.issueEndorsedBill("b3", "drawee", "owner", 3000)
+
+	mustOK(t, s.invoke("transferBill", transferArg("b1", "owner", "payee", 0)))
+	mustOK(t, s.invoke("acceptBillTransfer", "b1", "payeen"))
+
+	mustOK(t, s.applyLoan("l1", "b2", "owner", 1500, ""))
+	s.acceptOffer(bank, owner, "l1", "owner", "bank1", 1500)
+	mustOK(t, s.invoke("makeLoan", "l1", "bank1n", fmt.Sprint(s.now)))
+
+	// 升级前流转出去的票据记录在transferred_bill表中
+	s.putRaw(transferredBillTable, "owner", TransferredBill{Owner: "owner", Bills: []string{"b1", "b9"}})
+	legacy := testBill("b9", "drawee", "other", 500)
+	legacy.State = Endorsed
+	s.putRaw(billTable, "b9", legacy)
+
+	var pf Portfolio
+	decodeData(t, mustOK(t, s.invoke("queryPortfolio", "owner")), &pf)
+	if len(pf.Holdings) != 1 || pf.Holdings[0].BillID != "b3" || pf.HoldingAmount["yuan"] != 3000 {
+		t.Fatalf("unexpected holdings: %+v", pf)
+	}
+	if len(pf.Pledged) != 1 || pf.Pledged[0].BillID != "b2" || pf.PledgedAmount["yuan"] != 2000 {
+		t.Fatalf("unexpected pledged bills: %+v", pf)
+	}
+	if len(pf.Transferred) != 2 || pf.TransferredAmount["yuan"] != 1500 {
+		t.Fatalf("unexpected transferred bills: %+v", pf)
+	}
+
+	// 接收方的接收索引
+	key, _ := s.CreateCompositeKey(ReceivedIndex, []string{"payee", "b1"})
+	if s.State[key] == nil {
+		t.Fatal("received index entry is missing")
+	}
+
+	var received Portfolio
+	decodeData(t, mustOK(t, s.invoke("queryPortfolio", "payee")), &received)
+	if len(received.Holdings) != 1 || received.Holdings[0].BillID != "b1" || len(received.Transferred) != 0 {
+		t.Fatalf("unexpected portfolio: %+v", received)
+	}
+}
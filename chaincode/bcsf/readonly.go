@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// 客户端只评估不提交(如SDK的evaluateTransaction)时在transient中传入该键，写函数拒绝执行
+const EvaluateOnlyKey = "sf.evaluate"
+
+//readOnlyStub 只读查询使用的stub，读账本的调用原样转发，写账本的调用返回READ_ONLY
+type readOnlyStub struct {
+	shim.ChaincodeStubInterface
+	function string
+}
+
+func newReadOnlyStub(stub shim.ChaincodeStubInterface, function string) shim.ChaincodeStubInterface {
+	return readOnlyStub{stub, function}
+}
+
+func (s readOnlyStub) denied(key string) error {
+	return newError(ErrReadOnly, "the query function %s should not write the ledger", s.function).With("function", s.function).With("key", key)
+}
+
+func (s readOnlyStub) PutState(key string, value []byte) error {
+	return s.denied(key)
+}
+
+func (s readOnlyStub) DelState(key string) error {
+	return s.denied(key)
+}
+
+func (s readOnlyStub) SetStateValidationParameter(key string, ep []byte) error {
+	return s.denied(key)
+}
+
+func (s readOnlyStub) PutPrivateData(collection string, key string, value []byte) error {
+	return s.denied(key)
+}
+
+func (s readOnlyStub) DelPrivateData(collection, key string) error {
+	return s.denied(key)
+}
+
+func (s readOnlyStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	return s.denied(key)
+}
+
+// 调用是否只评估不提交，无法判断时按提交处理
+func evaluateOnly(stub shim.ChaincodeStubInterface) (bool, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return false, wrapError(err)
+	}
+
+	_, exist := transient[EvaluateOnlyKey]
+	return exist, nil
+}
+
+// 写函数在只评估不提交的调用中拒绝执行，避免客户端误以为已修改账本
+func refuseEvaluateOnly(stub shim.ChaincodeStubInterface, function string) error {
+	evaluate, err := evaluateOnly(stub)
+	if err != nil {
+		return err
+	}
+
+	if evaluate {
+		return newError(ErrReadOnly, "the function %s writes the ledger and should be submitted for ordering", function).With("function", function)
+	}
+
+	return nil
+}
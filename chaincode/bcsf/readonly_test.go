@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestQueryRoutesAreReadOnly(t *testing.T) {
+	for name, rt := range sfRoutes {
+		if (strings.HasPrefix(name, "query") || strings.HasPrefix(name, "list")) && !rt.ReadOnly {
+			t.Errorf("%s should be read-only", name)
+		}
+	}
+}
+
+func TestReadOnlyStubRejectsWrites(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.issueEndorsedBill("b1", "drawee", "owner", 1000)
+
+	// 查询函数写账本时返回READ_ONLY，写入不生效
+	sfRoutes["queryAndWrite"] = &Route{Name: "queryAndWrite", ReadOnly: true,
+		handler: func(sfb *SupplyFinance, stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+			bill, err := NewBillRepo(stub).Get("b1")
+			if err != nil {
+				return nil, err
+			}
+
+			bill.State = BillAbolished
+			return bill, NewBillRepo(stub).Put(bill)
+		}}
+	defer delete(sfRoutes, "queryAndWrite")
+
+	ret := mustFail(t, s.invoke("queryAndWrite"), ErrReadOnly)
+	if ret.Details["function"] != "queryAndWrite" || ret.Details["key"] != billTable.Prefix+"b1" {
+		t.Fatalf("unexpected details: %+v", ret.Details)
+	}
+
+	var bill Bill
+	s.getRecord(billTable, "b1", &bill)
+	if bill.State != Endorsed {
+		t.Fatalf("unexpected bill state: %s", bill.State)
+	}
+}
+
+func TestEvaluateOnlyRefusesWrites(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.issueEndorsedBill("b1", "drawee", "owner", 1000)
+
+	// 只评估不提交时写函数拒绝执行，查询函数照常返回
+	s.transient = map[string][]byte{EvaluateOnlyKey: []byte("true")}
+	mustFail(t, s.invoke("abolishBill", "b1", "ownern"), ErrReadOnly)
+	mustOK(t, s.invoke("queryByID", billTable.Name, "b1"))
+
+	s.transient = nil
+	mustOK(t, s.invoke("abolishBill", "b1", "ownern"))
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//tableDef 表定义，表名用于查询参数和错误信息，前缀用于组成账本key
+type tableDef struct {
+	Name    string
+	Prefix  string
+	Indexes []indexDef // 保存记录时同步维护的二级索引
+}
+
+// 表列表
+var (
+	billTable            = tableDef{"bill", "BILL_", billIndexes}
+	loanTable            = tableDef{"loan", "LOAN_", loanIndexes}
+	contractTable        = tableDef{"contract", "CNTR_", nil}
+	billChildTable       = tableDef{"bill_child", "BLCD_", nil}
+	billTransferTable    = tableDef{"bill_transfer", "BLTF_", nil}
+	loanRepaymentTable   = tableDef{"loan_repayment", "LNRP_", nil}
+	transferredBillTable = tableDef{"transferred_bill", "TFBL_", nil}
+)
+
+//Tables above，按表名查找表定义
+var SF_TABLES = map[string]tableDef{}
+
+func registerTables(tables ...tableDef) {
+	for _, t := range tables {
+		SF_TABLES[t.Name] = t
+		for _, idx := range t.Indexes {
+			SF_INDEXES[idx.Name] = t
+		}
+	}
+}
+
+func init() {
+	registerTables(billTable, loanTable, contractTable, billChildTable, billTransferTable, loanRepaymentTable, transferredBillTable)
+}
+
+// 按客户端传入的表名查找表定义
+func lookupTable(name string) (tableDef, error) {
+	t, exist := SF_TABLES[name]
+	if !exist {
+		return t, newError(ErrInvalidArg, "the table[%s] is not exist", name).With("table", name)
+	}
+
+	return t, nil
+}
+
+//keyedRecord 带主键的记录，读取时用于校验记录是否完整，保存时用于校验key
+type keyedRecord interface {
+	recordID() string
+}
+
+//validator 记录保存前的校验钩子
+type validator interface {
+	validate() error
+}
+
+//HistoryEntry 记录的一次修改历史
+type HistoryEntry struct {
+	TxId      string          // 交易ID
+	Value     json.RawMessage // 修改后的记录，删除时为null
+	Timestamp string          // 交易时间
+	IsDelete  bool            `json:",string"` // 是否删除
+}
+
+//Store 账本记录存取的通用接口，每张表对应一个Store
+type Store interface {
+	Key(id string) string
+	GetBytes(id string) ([]byte, error)
+	Get(id string, pObj interface{}) error
+	Find(id string, pObj interface{}) (bool, error)
+	Put(id string, obj interface{}) error
+	Exists(id string) (bool, error)
+	History(id string) ([]HistoryEntry, error)
+	Query(selector string) ([]json.RawMessage, error)
+}
+
+//docStore 以JSON文档形式保存记录的Store，key为表前缀加记录ID
+type docStore struct {
+	table tableDef
+	stub  shim.ChaincodeStubInterface
+}
+
+func newStore(stub shim.ChaincodeStubInterface, table tableDef) Store {
+	return docStore{table: table, stub: stub}
+}
+
+func (ds docStore) Key(id string) string {
+	return ds.table.Prefix + id
+}
+
+func (ds docStore) GetBytes(id string) ([]byte, error) {
+	obj_bytes, err := ds.stub.GetState(ds.Key(id))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return obj_bytes, nil
+}
+
+//Get 读取单条记录，记录不存在返回NOT_FOUND，记录无法解析或主键不符返回CORRUPT_RECORD
+// pObj: pointer to individual object
+func (ds docStore) Get(id string, pObj interface{}) error {
+	found, err := ds.Find(id, pObj)
+	if err != nil {
+		return err
+	} else if !found {
+		return errNotFound(ds.table.Name, id)
+	}
+
+	return nil
+}
+
+//Find 读取可选记录，记录不存在时返回false且不修改pObj
+// pObj: pointer to individual object
+func (ds docStore) Find(id string, pObj interface{}) (bool, error) {
+	obj_bytes, err := ds.GetBytes(id)
+	if err != nil {
+		return false, err
+	} else if obj_bytes == nil {
+		return false, nil
+	}
+
+	err = json.Unmarshal(obj_bytes, pObj)
+	if err != nil {
+		return false, errCorrupt(ds.table.Name, id, err.Error())
+	}
+
+	if r, ok := pObj.(keyedRecord); ok && r.recordID() != id {
+		return false, errCorrupt(ds.table.Name, id, "record id is not same with the key")
+	}
+
+	return true, nil
+}
+
+//Put 校验后保存记录
+func (ds docStore) Put(id string, obj interface{}) error {
+	if id == "" {
+		return newError(ErrInvalidArg, "the %s id should not be empty", ds.table.Name).With("table", ds.table.Name)
+	}
+
+	if r, ok := obj.(keyedRecord); ok && r.recordID() != id {
+		return newError(ErrInvalidArg, "the %s id is not same with the key", ds.table.Name).With("table", ds.table.Name).With("id", id)
+	}
+
+	if v, ok := obj.(validator); ok {
+		if err := v.validate(); err != nil {
+			return err
+		}
+	}
+
+	obj_bytes, err := json.Marshal(obj)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if len(ds.table.Indexes) > 0 {
+		old_bytes, err := ds.GetBytes(id)
+		if err != nil {
+			return err
+		}
+
+		err = updateIndexes(ds.stub, ds.table, id, old_bytes, obj_bytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ds.stub.PutState(ds.Key(id), obj_bytes)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if r, ok := obj.(endorsedRecord); ok {
+		return setKeyEndorsement(ds.stub, ds.Key(id), r.endorsers())
+	}
+
+	return nil
+}
+
+func (ds docStore) Exists(id string) (bool, error) {
+	obj_bytes, err := ds.GetBytes(id)
+	if err != nil {
+		return false, err
+	}
+
+	return obj_bytes != nil, nil
+}
+
+//History 按时间顺序返回记录的修改历史
+func (ds docStore) History(id string) ([]HistoryEntry, error) {
+	resultsIterator, err := ds.stub.GetHistoryForKey(ds.Key(id))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	defer resultsIterator.Close()
+
+	entries := make([]HistoryEntry, 0)
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		entry := HistoryEntry{TxId: response.TxId, IsDelete: response.IsDelete}
+		if !response.IsDelete {
+			entry.Value = json.RawMessage(response.Value)
+		}
+		if response.Timestamp != nil {
+			entry.Timestamp = time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String()
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+//Query CouchDB富查询，只返回本表的记录
+func (ds docStore) Query(selector string) ([]json.RawMessage, error) {
+	resultsIterator, err := ds.stub.GetQueryResult(selector)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	defer resultsIterator.Close()
+
+	records := make([]json.RawMessage, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		if strings.HasPrefix(queryResponse.Key, ds.table.Prefix) {
+			records = append(records, json.RawMessage(queryResponse.Value))
+		}
+	}
+
+	return records, nil
+}
+
+// 新增记录，记录已存在返回DUPLICATE
+func createRecord(s Store, table tableDef, id string, obj interface{}) error {
+	exist, err := s.Exists(id)
+	if err != nil {
+		return err
+	} else if exist {
+		return errDuplicate(table.Name, id)
+	}
+
+	return s.Put(id, obj)
+}
+
+// 逐条解析查询结果，解析失败返回CORRUPT_RECORD
+func decodeRecords(table tableDef, records []json.RawMessage, parse func(json.RawMessage) error) error {
+	for i, r := range records {
+		if err := parse(r); err != nil {
+			return errCorrupt(table.Name, strconv.Itoa(i), err.Error())
+		}
+	}
+
+	return nil
+}
+
+//BillRepo 票据表
+type BillRepo struct {
+	Store
+}
+
+func NewBillRepo(stub shim.ChaincodeStubInterface) BillRepo {
+	return BillRepo{newStore(stub, billTable)}
+}
+
+func (r BillRepo) Get(id string) (*Bill, error) {
+	var bill Bill
+	if err := r.Store.Get(id, &bill); err != nil {
+		return nil, err
+	}
+
+	return &bill, nil
+}
+
+// Find 票据不存在时返回nil
+func (r BillRepo) Find(id string) (*Bill, error) {
+	var bill Bill
+	found, err := r.Store.Find(id, &bill)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &bill, nil
+}
+
+func (r BillRepo) Put(bill *Bill) error {
+	return r.Store.Put(bill.BillID, *bill)
+}
+
+func (r BillRepo) Create(bill *Bill) error {
+	return createRecord(r.Store, billTable, bill.BillID, *bill)
+}
+
+func (r BillRepo) Query(selector string) ([]Bill, error) {
+	records, err := r.Store.Query(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	bills := make([]Bill, 0, len(records))
+	err = decodeRecords(billTable, records, func(rec json.RawMessage) error {
+		var bill Bill
+		if err := json.Unmarshal(rec, &bill); err != nil {
+			return err
+		}
+		bills = append(bills, bill)
+		return nil
+	})
+
+	return bills, err
+}
+
+//LoanRepo 贷款表
+type LoanRepo struct {
+	Store
+}
+
+func NewLoanRepo(stub shim.ChaincodeStubInterface) LoanRepo {
+	return LoanRepo{newStore(stub, loanTable)}
+}
+
+func (r LoanRepo) Get(id string) (*Loan, error) {
+	var loan Loan
+	if err := r.Store.Get(id, &loan); err != nil {
+		return nil, err
+	}
+
+	return &loan, nil
+}
+
+func (r LoanRepo) Put(loan *Loan) error {
+	return r.Store.Put(loan.LoanID, *loan)
+}
+
+func (r LoanRepo) Create(loan *Loan) error {
+	return createRecord(r.Store, loanTable, loan.LoanID, *loan)
+}
+
+func (r LoanRepo) Query(selector string) ([]Loan, error) {
+	records, err := r.Store.Query(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	loans := make([]Loan, 0, len(records))
+	err = decodeRecords(loanTable, records, func(rec json.RawMessage) error {
+		var loan Loan
+		if err := json.Unmarshal(rec, &loan); err != nil {
+			return err
+		}
+		loans = append(loans, loan)
+		return nil
+	})
+
+	return loans, err
+}
+
+//ContractRepo 合同表
+type ContractRepo struct {
+	Store
+}
+
+func NewContractRepo(stub shim.ChaincodeStubInterface) ContractRepo {
+	return ContractRepo{newStore(stub, contractTable)}
+}
+
+func (r ContractRepo) Get(id string) (*Contract, error) {
+	var ct Contract
+	if err := r.Store.Get(id, &ct); err != nil {
+		return nil, err
+	}
+
+	return &ct, nil
+}
+
+// Find 合同不存在时返回nil
+func (r ContractRepo) Find(id string) (*Contract, error) {
+	var ct Contract
+	found, err := r.Store.Find(id, &ct)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &ct, nil
+}
+
+func (r ContractRepo) Put(ct *Contract) error {
+	return r.Store.Put(ct.ContractID, *ct)
+}
+
+func (r ContractRepo) Create(ct *Contract) error {
+	return createRecord(r.Store, contractTable, ct.ContractID, *ct)
+}
+
+func (r ContractRepo) Query(selector string) ([]Contract, error) {
+	records, err := r.Store.Query(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	cts := make([]Contract, 0, len(records))
+	err = decodeRecords(contractTable, records, func(rec json.RawMessage) error {
+		var ct Contract
+		if err := json.Unmarshal(rec, &ct); err != nil {
+			return err
+		}
+		cts = append(cts, ct)
+		return nil
+	})
+
+	return cts, err
+}
+
+//LoanRepaymentRepo 贷款还款信息表
+type LoanRepaymentRepo struct {
+	Store
+}
+
+func NewLoanRepaymentRepo(stub shim.ChaincodeStubInterface) LoanRepaymentRepo {
+	return LoanRepaymentRepo{newStore(stub, loanRepaymentTable)}
+}
+
+func (r LoanRepaymentRepo) Get(id string) (*LoanRepayment, error) {
+	var lr LoanRepayment
+	if err := r.Store.Get(id, &lr); err != nil {
+		return nil, err
+	}
+
+	return &lr, nil
+}
+
+func (r LoanRepaymentRepo) Put(lr *LoanRepayment) error {
+	return r.Store.Put(lr.LoanID, *lr)
+}
+
+//BillChildRepo 拆分后父子票据关系表
+type BillChildRepo struct {
+	Store
+}
+
+func NewBillChildRepo(stub shim.ChaincodeStubInterface) BillChildRepo {
+	return BillChildRepo{newStore(stub, billChildTable)}
+}
+
+func (r BillChildRepo) Get(parentID string) (*BillChild, error) {
+	var bc BillChild
+	if err := r.Store.Get(parentID, &bc); err != nil {
+		return nil, err
+	}
+
+	return &bc, nil
+}
+
+// Find 票据未拆分时返回nil
+func (r BillChildRepo) Find(parentID string) (*BillChild, error) {
+	var bc BillChild
+	found, err := r.Store.Find(parentID, &bc)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &bc, nil
+}
+
+func (r BillChildRepo) Put(bc *BillChild) error {
+	return r.Store.Put(bc.ParentID, *bc)
+}
+
+//BillTransferRepo 票据流转表
+type BillTransferRepo struct {
+	Store
+}
+
+func NewBillTransferRepo(stub shim.ChaincodeStubInterface) BillTransferRepo {
+	return BillTransferRepo{newStore(stub, billTransferTable)}
+}
+
+//Find 票据未流转过时返回空的流转信息
+func (r BillTransferRepo) Find(billID string) (*BillTransfer, error) {
+	bt := BillTransfer{BillID: billID, Hops: []TransferHop{}}
+	if _, err := r.Store.Find(billID, &bt); err != nil {
+		return nil, err
+	}
+
+	return &bt, nil
+}
+
+func (r BillTransferRepo) Put(bt *BillTransfer) error {
+	return r.Store.Put(bt.BillID, *bt)
+}
+
+//TransferredBillRepo 企业流转出去的票据集合表，升级前的记录只读
+type TransferredBillRepo struct {
+	Store
+}
+
+func NewTransferredBillRepo(stub shim.ChaincodeStubInterface) TransferredBillRepo {
+	return TransferredBillRepo{newStore(stub, transferredBillTable)}
+}
+
+//Find 企业未流转过票据时返回空集合
+func (r TransferredBillRepo) Find(owner string) (*TransferredBill, error) {
+	tb := TransferredBill{Owner: owner}
+	if _, err := r.Store.Find(owner, &tb); err != nil {
+		return nil, err
+	}
+
+	return &tb, nil
+}
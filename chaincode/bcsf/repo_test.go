@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCorruptRecord(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+
+	// 无法解析的记录不能当作空记录处理
+	s.putRaw(billTable, "b1", "not a bill")
+	ret := mustFail(t, s.invoke("endorseBill", "b1", "draween"), ErrCorrupt)
+	if ret.Details["table"] != "bill" || ret.Details["id"] != "b1" {
+		t.Fatalf("unexpected details: %+v", ret.Details)
+	}
+
+	// 记录中的主键与key不符
+	s.putRaw(billTable, "b2", testBill("b3", "drawee", "owner", 1000))
+	mustFail(t, s.invoke("endorseBill", "b2", "draween"), ErrCorrupt)
+
+	mustFail(t, s.invoke("endorseBill", "b4", "draween"), ErrNotFound)
+}
+
+func TestRepoFind(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.issueEndorsedBill("b1", "drawee", "owner", 1000)
+
+	s.MockTransactionStart("find")
+	defer s.MockTransactionEnd("find")
+	repo := NewBillRepo(s)
+
+	// 可选记录不存在时返回nil，不是错误
+	bill, err := repo.Find("b2")
+	if bill != nil || err != nil {
+		t.Fatalf("expect nil bill, got %+v, %v", bill, err)
+	}
+
+	if _, err = repo.Get("b2"); err.(*sfError).Code != ErrNotFound {
+		t.Fatalf("expect NOT_FOUND, got %v", err)
+	}
+
+	bill, err = repo.Find("b1")
+	if err != nil || bill.State != Endorsed || bill.Owner != "owner" {
+		t.Fatalf("unexpected bill: %+v, %v", bill, err)
+	}
+}
+
+// 调用结果转换为错误码，成功时为空
+func errCode(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return wrapError(err).Code
+}
+
+func TestRepoPut(t *testing.T) {
+	s := newTestStub(t)
+	s.MockTransactionStart("put")
+	defer s.MockTransactionEnd("put")
+	repo := NewBillRepo(s)
+
+	bill := testBill("b1", "drawee", "owner", 1000)
+	// 保存前校验钩子：没有状态的票据不能保存
+	if code := errCode(repo.Put(&bill)); code != ErrInvalidArg {
+		t.Fatalf("expect INVALID_ARG, got %s", code)
+	}
+
+	bill.State = BillIssued
+	if code := errCode(repo.Create(&bill)); code != "" {
+		t.Fatalf("create bill failed: %s", code)
+	}
+	if code := errCode(repo.Create(&bill)); code != ErrDuplicate {
+		t.Fatalf("expect DUPLICATE, got %s", code)
+	}
+
+	// 记录主键须与key一致
+	if code := errCode(repo.Store.Put("b2", bill)); code != ErrInvalidArg {
+		t.Fatalf("expect INVALID_ARG, got %s", code)
+	}
+
+	if key := repo.Key("b1"); key != "BILL_b1" {
+		t.Fatalf("unexpected key: %s", key)
+	}
+}
+
+func TestLookupTable(t *testing.T) {
+	s := newTestStub(t)
+
+	// 未知表名不能组成空前缀的key
+	mustFail(t, s.invoke("queryByID", "bills", "b1"), ErrInvalidArg)
+	mustFail(t, s.invoke("queryTXChainForKey", "bills", "b1"), ErrInvalidArg)
+
+	if table, err := lookupTable("loan"); err != nil || table.Prefix != loanTable.Prefix {
+		t.Fatalf("unexpected table: %+v, %v", table, err)
+	}
+}
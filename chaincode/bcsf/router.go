@@ -0,0 +1,307 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 参数类型
+const (
+	ArgString = "string" // 字符串，原样传给处理函数
+	ArgInt    = "int"    // 整数，如毫秒时间、版本号、每页条数
+	ArgNumber = "number" // 数值，如金额
+	ArgJSON   = "json"   // JSON对象，有Schema时按结构体解析并校验
+)
+
+//handlerFunc 链码函数的处理函数，参数个数、类型和调用者角色已由路由检查；返回受影响的记录或查询结果，由路由编码为返回结构
+type handlerFunc func(sfb *SupplyFinance, stub shim.ChaincodeStubInterface, args Args) (interface{}, error)
+
+//Args 路由检查后的参数：int、number参数已转换，有结构体定义的json参数已解析并校验
+type Args struct {
+	raw    []string
+	values []interface{} // 转换后的值，string参数和没有结构体定义的json参数为原字符串
+}
+
+// 参数个数
+func (a Args) Len() int {
+	return len(a.raw)
+}
+
+// 是否传入了第i个参数，可选参数不传或传空字符串时返回false
+func (a Args) Has(i int) bool {
+	return i < len(a.raw) && a.raw[i] != ""
+}
+
+// 第i个参数的原字符串，未传入时返回空串
+func (a Args) String(i int) string {
+	if i >= len(a.raw) {
+		return ""
+	}
+
+	return a.raw[i]
+}
+
+// 第i个int参数，未传入时返回0
+func (a Args) Int(i int) int64 {
+	v, _ := a.value(i).(int64)
+	return v
+}
+
+// 第i个number参数，未传入时返回0
+func (a Args) Float(i int) float64 {
+	v, _ := a.value(i).(float64)
+	return v
+}
+
+// 第i个json参数解析出的结构体指针，未传入时返回nil
+func (a Args) Object(i int) interface{} {
+	if !a.Has(i) {
+		return nil
+	}
+
+	return a.value(i)
+}
+
+func (a Args) value(i int) interface{} {
+	if i >= len(a.values) {
+		return nil
+	}
+
+	return a.values[i]
+}
+
+//described 带描述信息的处理结果，处理函数需要返回非默认描述时使用，如等待其他审批
+type described struct {
+	desc string
+	data interface{}
+}
+
+// 用指定描述返回处理结果
+func describe(desc string, data interface{}) interface{} {
+	return described{desc, data}
+}
+
+//FieldSpec JSON参数的字段定义，供客户端生成代码
+type FieldSpec struct {
+	Name  string `json:"name"`            //JSON字段名
+	Type  string `json:"type"`            //字段类型
+	Rules string `json:"rules,omitempty"` //校验规则，即sf标签
+}
+
+//ArgSpec 位置参数定义
+type ArgSpec struct {
+	Name     string      `json:"name"`               //参数名
+	Type     string      `json:"type"`               //参数类型：string、int、number、json
+	Optional bool        `json:"optional,omitempty"` //是否可选，可选参数只能在最后
+	Schema   string      `json:"schema,omitempty"`   //JSON参数对应的结构体名
+	Fields   []FieldSpec `json:"fields,omitempty"`   //JSON参数的字段
+
+	newObj func() interface{} // 生成JSON参数对应结构体的指针
+}
+
+//Route 链码函数的注册信息
+type Route struct {
+	Name        string    `json:"name"`           //函数名
+	Description string    `json:"description"`    //函数说明
+	Args        []ArgSpec `json:"args"`           //位置参数
+	Role        string    `json:"role,omitempty"` //调用者须有的角色，为空时不限
+	ReadOnly    bool      `json:"read_only"`      //只读查询，不需要排序提交
+
+	handler handlerFunc
+}
+
+// 字符串参数
+func strArg(name string) ArgSpec {
+	return ArgSpec{Name: name, Type: ArgString}
+}
+
+// 整数参数
+func intArg(name string) ArgSpec {
+	return ArgSpec{Name: name, Type: ArgInt}
+}
+
+// 数值参数
+func numArg(name string) ArgSpec {
+	return ArgSpec{Name: name, Type: ArgNumber}
+}
+
+// 按结构体解析的JSON参数，newObj返回结构体指针
+func jsonArg(name string, newObj func() interface{}) ArgSpec {
+	t := reflect.TypeOf(newObj()).Elem()
+	return ArgSpec{Name: name, Type: ArgJSON, Schema: t.Name(), Fields: schemaFields(t), newObj: newObj}
+}
+
+// 可选参数
+func (a ArgSpec) optional() ArgSpec {
+	a.Optional = true
+	return a
+}
+
+// 按json和sf标签列出结构体的字段
+func schemaFields(t reflect.Type) []FieldSpec {
+	fields := make([]FieldSpec, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+
+		fields = append(fields, FieldSpec{Name: jsonFieldName(f), Type: f.Type.String(), Rules: f.Tag.Get(validateTag)})
+	}
+
+	return fields
+}
+
+// 路由表，函数名 -> 注册信息
+var sfRoutes = map[string]*Route{}
+
+func registerRoutes(routes ...Route) {
+	for i := range routes {
+		sfRoutes[routes[i].Name] = &routes[i]
+	}
+}
+
+// 必填参数个数
+func (rt *Route) requiredArgs() int {
+	n := 0
+	for _, a := range rt.Args {
+		if !a.Optional {
+			n++
+		}
+	}
+
+	return n
+}
+
+// 按参数定义检查参数个数和类型并转换，JSON参数按结构体解析并校验字段规则
+func (rt *Route) parseArgs(args []string) (Args, error) {
+	parsed := Args{raw: args, values: make([]interface{}, len(args))}
+	if len(args) < rt.requiredArgs() || len(args) > len(rt.Args) {
+		return parsed, errInvalidArgCount(rt.Name, len(rt.Args)).With("required_args", rt.requiredArgs())
+	}
+
+	for i, arg := range args {
+		spec := rt.Args[i]
+		parsed.values[i] = arg
+		if spec.Optional && arg == "" {
+			continue
+		}
+
+		var err error
+		switch spec.Type {
+		case ArgInt:
+			parsed.values[i], err = strconv.ParseInt(arg, 10, 64)
+		case ArgNumber:
+			parsed.values[i], err = strconv.ParseFloat(arg, 64)
+		case ArgJSON:
+			if spec.newObj != nil {
+				obj := spec.newObj()
+				if err := NewObjectFromJsonString(arg, obj); err != nil {
+					return parsed, wrapError(err).With("arg", spec.Name)
+				}
+				parsed.values[i] = obj
+			}
+		}
+
+		if err != nil {
+			return parsed, newError(ErrInvalidArg, "Chaincode Invoke %s failed: %s should be %s", rt.Name, spec.Name, spec.Type).With("arg", spec.Name).With("value", arg)
+		}
+	}
+
+	return parsed, nil
+}
+
+// 编码成功的返回结构，处理函数没有指定描述时，查询函数为"query success"，其他为"invoke <函数名> success"
+func (rt *Route) success(data interface{}) pb.Response {
+	if d, ok := data.(described); ok {
+		return retSuccess(d.desc, d.data)
+	}
+
+	if rt.ReadOnly {
+		return retSuccess("query success", data)
+	}
+
+	return retSuccess("invoke "+rt.Name+" success", data)
+}
+
+// 检查调用者角色：admin为配置中的管理员组织，其他角色取调用者证书属性
+func authorize(stub shim.ChaincodeStubInterface, role string) error {
+	if role == "" {
+		return nil
+	}
+
+	if role == RoleAdmin {
+		cfg, err := loadConfig(stub)
+		if err != nil {
+			return err
+		}
+
+		return requireAdmin(stub, cfg)
+	}
+
+	return requireRole(stub, role)
+}
+
+// 按路由表调用处理函数：检查并转换参数，检查调用者角色，查询函数使用只读stub，编码处理结果；处理函数panic时返回INTERNAL
+func (sfb *SupplyFinance) dispatch(stub shim.ChaincodeStubInterface, function string, args []string) (resp pb.Response) {
+	rt, exist := sfRoutes[function]
+	if !exist {
+		return retError(newError(ErrUnknownMethod, "Chaincode Unkown method: %s", function).With("function", function))
+	}
+
+	parsed, err := rt.parseArgs(args)
+	if err != nil {
+		return retError(err)
+	}
+
+	if err := authorize(stub, rt.Role); err != nil {
+		return retError(err)
+	}
+
+	// 查询函数不能写账本；写函数须排序提交，只评估时拒绝执行
+	if rt.ReadOnly {
+		stub = newReadOnlyStub(stub, function)
+	} else if err := refuseEvaluateOnly(stub, function); err != nil {
+		return retError(err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			resp = retError(newError(ErrInternal, "Chaincode Invoke %s failed: %v", function, r).With("function", function))
+		}
+	}()
+
+	data, err := rt.handler(sfb, stub, parsed)
+	if err != nil {
+		return retError(err)
+	}
+
+	return rt.success(data)
+}
+
+//listFunctions 按函数名顺序返回链码的全部函数及参数定义，供客户端生成代码
+//  args: 0 - Function Name Prefix，可选
+func (sfb *SupplyFinance) listFunctions(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	prefix := ""
+	if args.Len() > 0 {
+		prefix = args.String(0)
+	}
+
+	routes := make([]*Route, 0, len(sfRoutes))
+	for name, rt := range sfRoutes {
+		if strings.HasPrefix(name, prefix) {
+			routes = append(routes, rt)
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Name < routes[j].Name
+	})
+
+	return routes, nil
+}
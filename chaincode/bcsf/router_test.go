@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	rt := sfRoutes["issueContractBill"]
+	args, err := rt.parseArgs([]string{"ct1", "ownern", "b1", "100.5", "1600000000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if args.String(0) != "ct1" || args.Float(3) != 100.5 || args.Int(4) != 1600000000000 {
+		t.Fatalf("unexpected args: %+v", args.values)
+	}
+
+	_, err = rt.parseArgs([]string{"ct1", "ownern", "b1", "abc", "1600000000000"})
+	if e := wrapError(err); e.Code != ErrInvalidArg || e.Details["arg"] != "amount" {
+		t.Fatalf("expect INVALID_ARG on amount, got %+v", e)
+	}
+
+	// JSON参数解析为结构体指针，可选参数未传时为nil
+	rt = sfRoutes["endorseLoan"]
+	args, err = rt.parseArgs([]string{"l1", "guarantorn", `{"gt_fee":1}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if terms, ok := args.Object(2).(*GuaranteeTermsArg); !ok || terms == nil {
+		t.Fatalf("expect *GuaranteeTermsArg, got %T", args.Object(2))
+	}
+
+	args, err = rt.parseArgs([]string{"l1", "guarantorn", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Has(2) || args.Object(2) != nil {
+		t.Fatalf("expect no terms, got %v", args.Object(2))
+	}
+}
+
+func TestRouteSuccess(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "DraweeMSP", "drawee", ""))
+
+	// 处理函数指定的描述
+	ret := mustOK(t, s.invoke("issueBill", toJSON(t, testBill("b1", "drawee", "owner", 100))))
+	if ret.Description != "invoke issueBill success, waiting for the drawee's endorsement" {
+		t.Fatalf("unexpected description: %s", ret.Description)
+	}
+
+	// 写函数默认描述
+	ret = mustOK(t, s.invoke("endorseBill", "b1", "draween"))
+	if ret.Description != "invoke endorseBill success" {
+		t.Fatalf("unexpected description: %s", ret.Description)
+	}
+
+	// 只读函数默认描述
+	var bill Bill
+	ret = mustOK(t, s.invoke("queryByID", billTable.Name, "b1"))
+	decodeData(t, ret, &bill)
+	if ret.Description != "query success" || bill.BillID != "b1" {
+		t.Fatalf("unexpected response: %s, %+v", ret.Description, bill)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OtherMSP", "other", ""))
+
+	// 管理员角色统一由路由检查，处理函数不再重复检查
+	calls := map[string][]string{
+		"registerParticipant": {"bank1", "OtherMSP"},
+		"updateConfig":        {`{"admin_msps":["OtherMSP"],"day_count":"ACT/360","transfer_offer_ttl":1}`},
+		"rebuildIndexes":      {billTable.Name, "10"},
+		"setSplitRule":        {`{"sr_drawee":"drawee","max_split_depth":3}`},
+	}
+	for name, args := range calls {
+		if sfRoutes[name].Role != RoleAdmin {
+			t.Fatalf("%s should require the admin role", name)
+		}
+		mustFail(t, s.invoke(name, args...), ErrForbidden)
+	}
+}
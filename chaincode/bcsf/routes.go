@@ -0,0 +1,183 @@
+package main
+
+func init() {
+	registerRoutes(
+		// 票据
+		Route{Name: "issueBill", Description: "由合同关联或拆分票据而产生票据",
+			Args:    []ArgSpec{jsonArg("bill", func() interface{} { return &Bill{} }), strArg("mode").optional()},
+			handler: (*SupplyFinance).issueBill},
+		Route{Name: "endorseBill", Description: "核心企业同意担保票据",
+			Args:    []ArgSpec{strArg("bill_id"), strArg("drawee_name")},
+			handler: (*SupplyFinance).endorseBill},
+		Route{Name: "rejectBill", Description: "核心企业拒绝担保票据",
+			Args:    []ArgSpec{strArg("bill_id"), strArg("drawee_name")},
+			handler: (*SupplyFinance).rejectBill},
+		Route{Name: "transferBill", Description: "流转票据",
+			Args:    []ArgSpec{jsonArg("transfer", func() interface{} { return &TransferInfoArg{} })},
+			handler: (*SupplyFinance).transferBill},
+		Route{Name: "acceptBillTransfer", Description: "接收方接收流转的票据",
+			Args:    []ArgSpec{strArg("bill_id"), strArg("new_owner_name")},
+			handler: (*SupplyFinance).acceptBillTransfer},
+		Route{Name: "declineBillTransfer", Description: "接收方拒绝接收流转的票据",
+			Args:    []ArgSpec{strArg("bill_id"), strArg("new_owner_name")},
+			handler: (*SupplyFinance).declineBillTransfer},
+		Route{Name: "cancelBillTransfer", Description: "持票人撤回流转",
+			Args:    []ArgSpec{strArg("bill_id"), strArg("owner_name")},
+			handler: (*SupplyFinance).cancelBillTransfer},
+		Route{Name: "redeemBill", Description: "已还款，赎回票据",
+			Args:    []ArgSpec{strArg("bill_id"), strArg("drawee_name")},
+			handler: (*SupplyFinance).redeemBill},
+		Route{Name: "abolishBill", Description: "票据持有人废弃票据",
+			Args:    []ArgSpec{strArg("bill_id"), strArg("owner_name")},
+			handler: (*SupplyFinance).abolishBill},
+		Route{Name: "countersignAbolish", Description: "还款人会签作废流转过的票据",
+			Args:    []ArgSpec{strArg("bill_id"), strArg("drawee_name")},
+			handler: (*SupplyFinance).countersignAbolish},
+		Route{Name: "rejectAbolish", Description: "还款人拒绝作废流转过的票据",
+			Args:    []ArgSpec{strArg("bill_id"), strArg("drawee_name")},
+			handler: (*SupplyFinance).rejectAbolish},
+		Route{Name: "splitBill", Description: "票据持有人拆分票据",
+			Args:    []ArgSpec{jsonArg("split", func() interface{} { return &BillSplitInfoArg{} })},
+			handler: (*SupplyFinance).splitBill},
+		Route{Name: "endorseBills", Description: "核心企业批量担保票据",
+			Args:    []ArgSpec{jsonArg("batch", func() interface{} { return &EndorseBillsArg{} })},
+			handler: (*SupplyFinance).endorseBills},
+		Route{Name: "transferBills", Description: "批量流转票据",
+			Args:    []ArgSpec{jsonArg("batch", func() interface{} { return &TransferBillsArg{} })},
+			handler: (*SupplyFinance).transferBills},
+		Route{Name: "payWithBill", Description: "票据持有人用票据部分支付",
+			Args:    []ArgSpec{jsonArg("payment", func() interface{} { return &PayWithBillArg{} })},
+			handler: (*SupplyFinance).payWithBill},
+
+		// 合同
+		Route{Name: "issueContract", Description: "上传并生成合同",
+			Args:    []ArgSpec{jsonArg("contract", func() interface{} { return &Contract{} })},
+			handler: (*SupplyFinance).issueContract},
+		Route{Name: "issueContracts", Description: "批量上传并生成合同",
+			Args:    []ArgSpec{jsonArg("batch", func() interface{} { return &IssueContractsArg{} })},
+			handler: (*SupplyFinance).issueContracts},
+		Route{Name: "endorseContract", Description: "核心企业同意担保合同",
+			Args:    []ArgSpec{strArg("contract_id"), strArg("drawee_name"), strArg("bill_id"), intArg("bill_create_date"), intArg("ct_version")},
+			handler: (*SupplyFinance).endorseContract},
+		Route{Name: "rejectContract", Description: "核心企业拒绝担保合同",
+			Args:    []ArgSpec{strArg("contract_id"), strArg("drawee_name"), strArg("refused_reason")},
+			handler: (*SupplyFinance).rejectContract},
+		Route{Name: "amendContract", Description: "发起人修改合同",
+			Args:    []ArgSpec{jsonArg("contract", func() interface{} { return &Contract{} })},
+			handler: (*SupplyFinance).amendContract},
+		Route{Name: "issueContractBill", Description: "按已担保的合同分批生成票据",
+			Args:    []ArgSpec{strArg("contract_id"), strArg("owner_name"), strArg("bill_id"), numArg("amount"), intArg("bill_create_date")},
+			handler: (*SupplyFinance).issueContractBill},
+		Route{Name: "closeContract", Description: "合同履行完毕",
+			Args:    []ArgSpec{strArg("contract_id"), strArg("owner_name")},
+			handler: (*SupplyFinance).closeContract},
+		Route{Name: "terminateContract", Description: "还款人终止合同",
+			Args:    []ArgSpec{strArg("contract_id"), strArg("drawee_name"), strArg("reason")},
+			handler: (*SupplyFinance).terminateContract},
+		Route{Name: "setApprovalPolicy", Description: "设置参与方的担保审批策略",
+			Args:    []ArgSpec{jsonArg("policy", func() interface{} { return &ApprovalPolicy{} })},
+			handler: (*SupplyFinance).setApprovalPolicy},
+
+		// 贷款
+		Route{Name: "applyGuarantee", Description: "申请贷款前，需要信用企业先担保贷款",
+			Args:    []ArgSpec{jsonArg("loan", func() interface{} { return &Loan{} })},
+			handler: (*SupplyFinance).applyGuarantee},
+		Route{Name: "endorseLoan", Description: "核心企业同意为供应商贷款担保",
+			Args:    []ArgSpec{strArg("loan_id"), strArg("guarantor_name"), jsonArg("terms", func() interface{} { return &GuaranteeTermsArg{} }).optional()},
+			handler: (*SupplyFinance).endorseLoan},
+		Route{Name: "rejectLoan", Description: "核心企业拒绝为供应商贷款担保",
+			Args:    []ArgSpec{strArg("loan_id"), strArg("guarantor_name"), strArg("refused_reason")},
+			handler: (*SupplyFinance).rejectLoan},
+		Route{Name: "applyLoanAfterGuarantee", Description: "担保成功后，票据持有人继续申请贷款",
+			Args:    []ArgSpec{strArg("loan_id"), strArg("owner_name")},
+			handler: (*SupplyFinance).applyLoanAfterGuarantee},
+		Route{Name: "applyLoan", Description: "票据持有人申请贷款",
+			Args:    []ArgSpec{jsonArg("loan", func() interface{} { return &Loan{} })},
+			handler: (*SupplyFinance).applyLoan},
+		Route{Name: "withdrawLoan", Description: "贷款人撤回贷款申请",
+			Args:    []ArgSpec{strArg("loan_id"), strArg("owner_name")},
+			handler: (*SupplyFinance).withdrawLoan},
+		Route{Name: "submitLoanOffer", Description: "金融机构对贷款申请报价", Role: RoleBank,
+			Args:    []ArgSpec{jsonArg("offer", func() interface{} { return &LoanOffer{} })},
+			handler: (*SupplyFinance).submitLoanOffer},
+		Route{Name: "acceptLoanOffer", Description: "贷款人接受金融机构的报价",
+			Args:    []ArgSpec{strArg("loan_id"), strArg("owner_name"), strArg("bank")},
+			handler: (*SupplyFinance).acceptLoanOffer},
+		Route{Name: "refuseLoan", Description: "金融机构拒绝给票据持有人贷款", Role: RoleBank,
+			Args:    []ArgSpec{jsonArg("result", func() interface{} { return &LoanResultArg{} })},
+			handler: (*SupplyFinance).refuseLoan},
+		Route{Name: "makeLoan", Description: "金融机构同意贷款后放贷",
+			Args:    []ArgSpec{strArg("loan_id"), strArg("bank_name"), intArg("make_loan_date")},
+			handler: (*SupplyFinance).makeLoan},
+		Route{Name: "prepayLoan", Description: "贷款人申请提前还款",
+			Args:    []ArgSpec{strArg("loan_id"), strArg("owner_name")},
+			handler: (*SupplyFinance).prepayLoan},
+		Route{Name: "repayLoan", Description: "贷款人还款",
+			Args:    []ArgSpec{jsonArg("repayment", func() interface{} { return &LoanRepaymentArg{} })},
+			handler: (*SupplyFinance).repayLoan},
+		Route{Name: "setCreditFacility", Description: "金融机构设置给核心企业的授信额度", Role: RoleBank,
+			Args:    []ArgSpec{jsonArg("facility", func() interface{} { return &CreditFacility{} })},
+			handler: (*SupplyFinance).setCreditFacility},
+		Route{Name: "callGuarantee", Description: "金融机构要求担保方承担还款责任",
+			Args:    []ArgSpec{strArg("loan_id"), strArg("bank_name")},
+			handler: (*SupplyFinance).callGuarantee},
+
+		// 配置
+		Route{Name: "registerParticipant", Description: "管理员登记参与方系统账号所属的组织", Role: RoleAdmin,
+			Args:    []ArgSpec{strArg("participant"), strArg("msp_id")},
+			handler: (*SupplyFinance).registerParticipant},
+		Route{Name: "updateConfig", Description: "管理员修改链码配置", Role: RoleAdmin,
+			Args:    []ArgSpec{jsonArg("config", func() interface{} { return &Config{} })},
+			handler: (*SupplyFinance).updateConfig},
+		Route{Name: "rebuildIndexes", Description: "管理员为升级前的记录补写二级索引", Role: RoleAdmin,
+			Args:    []ArgSpec{strArg("table"), intArg("page_size"), strArg("bookmark").optional()},
+			handler: (*SupplyFinance).rebuildIndexes},
+		Route{Name: "setSplitRule", Description: "管理员设置核心企业的票据拆分规则", Role: RoleAdmin,
+			Args:    []ArgSpec{jsonArg("rule", func() interface{} { return &SplitRule{} })},
+			handler: (*SupplyFinance).setSplitRule},
+
+		// 查询
+		Route{Name: "getConfig", Description: "查询链码配置", ReadOnly: true,
+			Args:    []ArgSpec{strArg("option").optional()},
+			handler: (*SupplyFinance).getConfig},
+		Route{Name: "querySplitRule", Description: "查询核心企业的票据拆分规则", ReadOnly: true,
+			Args:    []ArgSpec{strArg("drawee")},
+			handler: (*SupplyFinance).querySplitRule},
+		Route{Name: "queryBillTransferChain", Description: "查询票据背书流转链", ReadOnly: true,
+			Args:    []ArgSpec{strArg("bill_id")},
+			handler: (*SupplyFinance).queryBillTransferChain},
+		Route{Name: "queryPortfolio", Description: "查询企业的票据资产", ReadOnly: true,
+			Args:    []ArgSpec{strArg("owner")},
+			handler: (*SupplyFinance).queryPortfolio},
+		Route{Name: "queryExposure", Description: "查询金融机构的风险敞口", ReadOnly: true,
+			Args:    []ArgSpec{strArg("bank")},
+			handler: (*SupplyFinance).queryExposure},
+		Route{Name: "queryPendingApprovals", Description: "查询参与方待签名的审批", ReadOnly: true,
+			Args:    []ArgSpec{strArg("participant")},
+			handler: (*SupplyFinance).queryPendingApprovals},
+		Route{Name: "queryBillChilds", Description: "查询票据拆分后的子票据ID集合", ReadOnly: true,
+			Args:    []ArgSpec{strArg("bill_id")},
+			handler: (*SupplyFinance).queryBillChilds},
+		Route{Name: "queryBillTree", Description: "查询票据从原始合同到所有子票据的拆分树", ReadOnly: true,
+			Args:    []ArgSpec{strArg("bill_id")},
+			handler: (*SupplyFinance).queryBillTree},
+		Route{Name: "queryByID", Description: "按唯一ID查询单条记录", ReadOnly: true,
+			Args:    []ArgSpec{strArg("table"), strArg("id")},
+			handler: (*SupplyFinance).queryByID},
+		Route{Name: "queryAll", Description: "按条件查询多条记录", ReadOnly: true,
+			Args:    []ArgSpec{{Name: "selector", Type: ArgJSON}},
+			handler: (*SupplyFinance).queryAll},
+		Route{Name: "queryBillsWithPagination", Description: "按条件分页查询", ReadOnly: true,
+			Args:    []ArgSpec{{Name: "selector", Type: ArgJSON}, intArg("page_size"), strArg("bookmark")},
+			handler: (*SupplyFinance).queryBillsWithPagination},
+		Route{Name: "queryTXChainForKey", Description: "查询票据或贷款的交易历史", ReadOnly: true,
+			Args:    []ArgSpec{strArg("table"), strArg("id")},
+			handler: (*SupplyFinance).queryTXChainForKey},
+		Route{Name: "queryByIndex", Description: "按二级索引分页查询", ReadOnly: true,
+			Args:    []ArgSpec{strArg("index"), strArg("value"), intArg("page_size"), strArg("bookmark")},
+			handler: (*SupplyFinance).queryByIndex},
+		Route{Name: "listFunctions", Description: "查询链码的全部函数及参数定义", ReadOnly: true,
+			Args:    []ArgSpec{strArg("prefix").optional()},
+			handler: (*SupplyFinance).listFunctions},
+	)
+}
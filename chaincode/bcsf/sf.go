@@ -3,9 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"time"
-	"strconv"
 	"bytes"
+	"strings"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -20,20 +21,23 @@ const (
 	BillAbolished	= "abolished"	// 把票据作废
 	BillSplit	= "split"	// 拆分票据
 	BillRedeemed	= "redeemed"	// 已还款，票据赎回
+	BillTransferring	= "transferring"	// 票据流转中，等待接收方确认
+	BillAbolishing	= "abolishing"	// 流转过的票据申请作废，等待还款人会签
 	LoanGurantee	= "untrusted"	// 申请信用企业为贷款提供担保
 	LoanApplied	= "applied"	// 贷款已经申请，等待银行审批
 	LoanRefused	= "refused"	// 银行拒绝贷款
 	LoanApproved	= "approved"	// 银行同意贷款
 	LoanLoaned	= "loaned"	// 银行放款
 	LoanRepaid	= "repaid"	// 贷款已还款
+	LoanWithdrawn	= "withdrawn"	// 贷款人撤回贷款申请
+	LoanCalled	= "called"	// 借款人违约，担保方承担还款责任
 	ContractUploaded= "uploaded"	// 合同已经上传
+	ContractClosed	= "closed"	// 合同履行完毕，不能再生成票据
+	ContractTerminated	= "terminated"	// 还款人终止合同，不能再生成票据
 	Endorsed	= "endorsed"	// 同意为合同或票据或贷款担保
 	Rejected	= "rejected"	// 拒绝为合同或票据或贷款担保
 )
 
-// 原始票据最大拆分深度/次数
-const SplitThreshold = 1
-
 //Loan 贷款信息基本结构
 type Loan struct {
 	LoanID		string	`json:"loan_id"`	//贷款编号
@@ -53,6 +57,24 @@ type Loan struct {
 	RepaymentDate   int64	`json:"repayment_date"`			//还款时间
 	RefuseReason	string	`json:"refused_reason,omitempty"`	//拒绝贷款原因
 	ApplyDate	int64	`json:"apply_date"`	//贷款申请/创建时间
+	Drawee		string	`json:"ln_drawee,omitempty" sf:"readonly"`	//票据还款人(核心企业)系统账号，审批时记录
+	CreditUsed	float64	`json:"credit_used,omitempty" sf:"readonly"`	//占用金融机构给还款人的授信额度，还款后释放
+	GuaranteeID	string	`json:"guarantee_id,omitempty" sf:"readonly"`	//担保合同编号，担保方担保贷款时生成
+	Obligor		string	`json:"obligor,omitempty" sf:"readonly"`	//担保被要求承担责任后的还款责任方(担保方)系统账号
+	UncoveredAmount	float64	`json:"uncovered_amount,omitempty" sf:"readonly"`	//担保被要求承担责任后，担保未覆盖、仍由贷款人偿还的本金
+}
+
+func (ln Loan) recordID() string {
+	return ln.LoanID
+}
+
+// 保存前校验：记录必须带有状态
+func (ln Loan) validate() error {
+	if ln.State == "" {
+		return newError(ErrInvalidArg, "the loan state should not be empty").With("id", ln.recordID())
+	}
+
+	return nil
 }
 
 func (ln Loan) ValidateGuarantorName(expectedV string) bool {
@@ -100,12 +122,18 @@ type LoanResultArg struct {
 type LoanRepayment struct {
 	LoanID		string	`json:"lr_loan_id"`	//贷款编号
 	IsPrepayment	bool	`json:"prepayment"` //是否提前还款
-	makeLoanDate	int64	`json:"make_loan_date,omitempty"`	//贷款放款时间
+	MakeLoanDate	int64	`json:"make_loan_date,omitempty"`	//贷款放款时间
 	ActualRepaymentDate   int64	`json:"actual_repayment_date,omitempty"`	//实际还款时间
 	ActualAmount		float64	`json:"actual_lr_amount,omitempty"`	//贷款实际还款金额
 	AmountUnit	string	`json:"ln_amount_unit,omitempty"`	//金额单位，元或美元等
 	ActualBankRate	float64	`json:"actual_bank_rate,omitempty"`	//还款时的贷款利率
 	ActualBankInterest	float64	`json:"actual_bank_interest,omitempty"`	//还款时的贷款利息
+	DayCount	string	`json:"day_count,omitempty"`	//链码计算利息时使用的计息天数惯例
+	RepaidAmount	float64	`json:"repaid_amount,omitempty"`	//担保被要求承担责任后，担保方和贷款人累计的还款金额
+}
+
+func (lr LoanRepayment) recordID() string {
+	return lr.LoanID
 }
 
 //LoanRepaymentArg 还贷信息参数
@@ -121,25 +149,41 @@ type LoanRepaymentArg struct {
 
 //Contract 合同基本结构
 type Contract struct {
-	ContractID	string	`json:"contract_id"`	//合同号
-	HashID		string	`json:"hash_id"`	//合同内容的hash值
+	ContractID	string	`json:"contract_id" sf:"required"`	//合同号
+	HashID		string	`json:"hash_id" sf:"required"`	//合同内容的hash值
 	BillHashID	string	`json:"bill_hash_id,omitempty"`	//票据内容的hash值，线下上传票据文件时通过文件内容计算
-	Amount		float64	`json:"ct_amount"`		//合同金额
-	AmountUnit	string	`json:"ct_amount_unit"`	//金额单位，元或美元等
-	IssueDate	int64	`json:"ct_issue_date"`	//开始日期
-	DueDate		int64	`json:"ct_due_date"`	//到期日期
-	PyeeName	string	`json:"ct_pyee_name"`	//收款人名称
+	Amount		float64	`json:"ct_amount" sf:"gt=0"`		//合同金额
+	AmountUnit	string	`json:"ct_amount_unit" sf:"required"`	//金额单位，元或美元等
+	IssueDate	int64	`json:"ct_issue_date" sf:"required"`	//开始日期
+	DueDate		int64	`json:"ct_due_date" sf:"gtfield=IssueDate"`	//到期日期
+	PyeeName	string	`json:"ct_pyee_name" sf:"required"`	//收款人名称
 	PyeeID		string	`json:"ct_pyee_id"`	//收款人身份号
 	PyeeAcct	string	`json:"ct_pyee_acct"`	//收款人账户
-	Drawee		string	`json:"ct_drawee"`		//还款人系统账号
-	DraweeName	string	`json:"ct_drawee_name"`	//还款人名称
-	Issuer		string	`json:"ct_issuer"`		//发起人系统账号
-	IssuerName	string	`json:"ct_issuer_name"`	//发起人名称
-	Owner		string	`json:"ct_owner"`		//持有人系统账号
-	OwnerName	string	`json:"ct_owner_name"`	//持有人名称
-	State		string	`json:"ct_state"`		//合同状态
-	RefuseReason	string	`json:"refused_reason,omitempty"`	//拒绝担保合同原因
+	Drawee		string	`json:"ct_drawee" sf:"required"`		//还款人系统账号
+	DraweeName	string	`json:"ct_drawee_name" sf:"required,nefield=PyeeName"`	//还款人名称
+	Issuer		string	`json:"ct_issuer" sf:"required"`		//发起人系统账号
+	IssuerName	string	`json:"ct_issuer_name" sf:"required"`	//发起人名称
+	Owner		string	`json:"ct_owner" sf:"required"`		//持有人系统账号
+	OwnerName	string	`json:"ct_owner_name" sf:"required"`	//持有人名称
+	State		string	`json:"ct_state" sf:"readonly"`		//合同状态
+	RefuseReason	string	`json:"refused_reason,omitempty" sf:"readonly"`	//拒绝担保或终止合同原因
 	CreateDate	int64	`json:"ct_create_date"`//记录创建时间
+	Version		int32	`json:"ct_version" sf:"readonly"`	//合同版本号，上传时为1，每次修改加1
+	EndorsedVersion	int32	`json:"ct_endorsed_version,omitempty" sf:"readonly"`	//还款人担保的合同版本号
+	BilledAmount	float64	`json:"billed_amount" sf:"readonly"`	//已生成票据的金额，不超过合同金额
+}
+
+func (ct Contract) recordID() string {
+	return ct.ContractID
+}
+
+// 保存前校验：记录必须带有状态
+func (ct Contract) validate() error {
+	if ct.State == "" {
+		return newError(ErrInvalidArg, "the contract state should not be empty").With("id", ct.recordID())
+	}
+
+	return nil
 }
 
 func (ct Contract) ValidateDraweeName(expectedV string) bool {
@@ -153,23 +197,23 @@ func (ct Contract) ValidateDraweeName(expectedV string) bool {
 //Bill 票据基本结构
 type Bill struct {
 	ParentID	string	`json:"parent_id"`	//票据来源，生成票据的合同号或被拆分的票据号
-	BillID		string	`json:"bill_id"`	//票据号
-	Amount		float64	`json:"amount"`		//票据金额
-	AmountUnit	string	`json:"amount_unit"`	//金额单位，元或美元等
-	IssueDate	int64	`json:"issue_date"`	//票据出票日期
-	DueDate		int64	`json:"due_date"`	//票据到期日期
-	PyeeName	string	`json:"pyee_name"`	//收款人名称
+	BillID		string	`json:"bill_id" sf:"required"`	//票据号
+	Amount		float64	`json:"amount" sf:"gt=0"`		//票据金额
+	AmountUnit	string	`json:"amount_unit" sf:"required"`	//金额单位，元或美元等
+	IssueDate	int64	`json:"issue_date" sf:"required"`	//票据出票日期
+	DueDate		int64	`json:"due_date" sf:"gtfield=IssueDate"`	//票据到期日期
+	PyeeName	string	`json:"pyee_name" sf:"required"`	//收款人名称
 	PyeeID		string	`json:"pyee_id"`	//收款人身份号
 	PyeeAcct	string	`json:"pyee_acct"`	//收款人账户
-	Drawee		string	`json:"drawee"`		//还款人系统账号
-	DraweeName	string	`json:"drawee_name"`	//还款人名称
+	Drawee		string	`json:"drawee" sf:"required"`		//还款人系统账号
+	DraweeName	string	`json:"drawee_name" sf:"required,nefield=PyeeName"`	//还款人名称
 	Issuer		string	`json:"issuer"`		//票据发起人系统账号
 	IssuerName	string	`json:"issuer_name"`	//票据发起人名称
-	Owner		string	`json:"owner"`		//持票人系统账号
-	OwnerName	string	`json:"owner_name"`	//持票人名称
-	State		string	`json:"state"`		//票据状态(omitempty,json反序列化显示给客户端时不返回空字段)
-	SplitCount	int32	`json:"split_count"`    //控制原始票据拆分次数，该值表示当前票据是通过几次拆分而生成的
-	Transferred	bool	`json:"transferred"`    //票据是否流转过，false - 没流转过的票据，true - 流转过的票据
+	Owner		string	`json:"owner" sf:"required"`		//持票人系统账号
+	OwnerName	string	`json:"owner_name" sf:"required"`	//持票人名称
+	State		string	`json:"state" sf:"readonly"`		//票据状态(omitempty,json反序列化显示给客户端时不返回空字段)
+	SplitCount	int32	`json:"split_count" sf:"readonly"`    //控制原始票据拆分次数，该值表示当前票据是通过几次拆分而生成的
+	Transferred	bool	`json:"transferred" sf:"readonly"`    //票据是否流转过，false - 没流转过的票据，true - 流转过的票据
 	CreateDate	int64	`json:"bill_create_date"`//记录创建时间
 }
 
@@ -184,6 +228,16 @@ func getSecAndNSec(milliSec int64) (int64, int64){
 	return Sec,NSec
 }
 
+// 取交易时间，单位毫秒，各背书节点一致
+func getTxTimeMillis(stub shim.ChaincodeStubInterface) (int64, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, wrapError(err)
+	}
+
+	return ts.Seconds * THOUSAND + int64(ts.Nanos) / TEN_MILLION, nil
+}
+
 func afterNowDate(t time.Time) bool {
 	now := time.Now()
 	
@@ -202,6 +256,19 @@ func afterNowDate(t time.Time) bool {
 	return false
 }
 
+func (bl Bill) recordID() string {
+	return bl.BillID
+}
+
+// 保存前校验：记录必须带有状态
+func (bl Bill) validate() error {
+	if bl.State == "" {
+		return newError(ErrInvalidArg, "the bill state should not be empty").With("id", bl.recordID())
+	}
+
+	return nil
+}
+
 func (bl Bill) ValidateDueDate() bool {
 	// 检查票据的到期时间是否已过期
 	Sec, NSec := getSecAndNSec(bl.DueDate)
@@ -254,17 +321,36 @@ func (bl Bill) ValidateState(expectedV string) bool {
 	return false
 }
 
-//TransferredBill 企业流转出去的票据集合结构
+//TransferredBill 企业流转出去的票据集合结构，已由transferred~bill索引代替，只读取升级前的记录
 type TransferredBill struct {
 	Owner	string		`json:"tb_owner"`	//企业系统账号
 	Bills	[]string	`json:"bills"`	//票据号集合
 }
 
-//BillTransfer 票据流转信息结构
+func (tb TransferredBill) recordID() string {
+	return tb.Owner
+}
+
+//BillTransfer 票据背书流转链，按流转顺序保存该票据的每一次流转，拆分前的流转保存在父票据中
 type BillTransfer struct {
-	BillID		string	//票据编号
-	Count		int		//票据已经流转的总次数
-	Transfers	map[int]TransferInfoArg  //key：流转次数序号，从1开始
+	BillID		string		`json:"bt_bill_id"`	//票据编号
+	Hops		[]TransferHop	`json:"hops"`	//流转记录，按流转顺序排列
+}
+
+//TransferHop 票据的一次流转
+type TransferHop struct {
+	Seq		int	`json:"seq"`	//该票据的流转序号，从1开始
+	BillID		string	`json:"bill_id"`	//流转的票据编号
+	OldOwner	string	`json:"old_owner"`	//流转前票据所有者系统账号
+	OldOwnerName	string	`json:"old_owner_name"`	//流转前票据所有者名称
+	NewOwner	string	`json:"new_owner"`	//流转后票据所有者系统账号
+	NewOwnerName	string	`json:"new_owner_name"`	//流转后票据所有者名称
+	TxID		string	`json:"tx_id"`	//流转生效的交易ID
+	Timestamp	int64	`json:"hop_date"`	//流转生效的交易时间，毫秒
+}
+
+func (bt BillTransfer) recordID() string {
+	return bt.BillID
 }
 
 //TransferInfoArg 流转信息参数
@@ -274,6 +360,7 @@ type TransferInfoArg struct {
 	OldOwnerName	string	`json:"old_owner_name"`	//流转前票据所有者名称
 	NewOwner		string	`json:"new_owner"`	//流转后票据所有者系统账号
 	NewOwnerName	string	`json:"new_owner_name"`	//流转后票据所有者名称
+	OfferTTL		int64	`json:"offer_ttl,omitempty"`	//等待接收方确认的有效期(毫秒)，0表示默认有效期
 }
 
 //BillChild 拆分后父子票据关系基本结构
@@ -282,12 +369,17 @@ type BillChild struct {
 	Childs		[]string	`json:"child_bills"`	//子票据号集合
 }
 
+func (bc BillChild) recordID() string {
+	return bc.ParentID
+}
+
 //BillSplitInfo 票据拆分参数结构
 type BillSplitInfoArg struct {
 	BillID		string		`json:"bill_id"`	//票据号
 	OwnerName	string		`json:"owner_name"`	//持票人名称
 	SplitDate	int64	`json:"split_date"`//票据拆分时间
 	Childs		[]BillChildArg	`json:"child_bills"`	//待拆分的票据
+	OfferTTL	int64	`json:"offer_ttl,omitempty"`	//子票据流转给他人时，等待接收方确认的有效期(毫秒)，0表示默认有效期
 }
 
 //BillChildArg 子票据参数结构
@@ -298,6 +390,22 @@ type BillChildArg struct {
 	Amount		float64	`json:"amount"`		//票据金额
 }
 
+//PayWithBillArg 票据部分支付参数结构
+type PayWithBillArg struct {
+	BillID		string	`json:"bill_id"`	//被拆分支付的票据号
+	OwnerName	string	`json:"owner_name"`	//持票人名称
+	Amount		float64	`json:"pay_amount"`	//支付金额
+	Payee		string	`json:"payee"`	//收款方系统账号
+	PayeeName	string	`json:"payee_name"`	//收款方名称
+	PayBillID	string	`json:"pay_bill_id"`	//支付给收款方的子票据号
+	RemainBillID	string	`json:"remain_bill_id"`	//持票人保留的子票据号
+	PayDate		int64	`json:"pay_date"`	//支付时间
+	OfferTTL	int64	`json:"offer_ttl,omitempty"`	//等待收款方确认的有效期(毫秒)，0表示默认有效期
+}
+
+// issueBill的迁移模式参数
+const IssueModeMigrate = "migrate"
+
 //TableDataArg 表数据记录新增、修改及查询参数结构
 type TableDataArg struct {
 	TableName	string		`json:"table_name"`	//表名
@@ -310,91 +418,6 @@ const (
 	ObjectNew	= 2
 )
 
-//Tables above
-var SF_TABLES = map[string]string{
-	"bill": "BILL_",
-	"loan": "LOAN_",
-	"contract": "CNTR_",
-	"bill_child": "BLCD_",
-	"bill_transfer": "BLTF_",
-	"loan_repayment": "LNRP_",
-	"transferred_bill": "TFBL_",
-}
-
-type DocTable struct{
-	Name string
-	stub shim.ChaincodeStubInterface
-}
-
-func (dt DocTable) composeKey(id string) string {
-	return SF_TABLES[dt.Name] + id
-}
-
-func (dt DocTable) GetObjectBytes(id string) ([]byte, error) {
-	obj_bytes, err := dt.stub.GetState(dt.composeKey(id))
-
-	if err != nil {
-		return nil, err
-	}
-
-	return obj_bytes, nil
-
-}
-
-// pObj: pointer to individual object
-func (dt DocTable) GetObject(id string, pObj interface{}) error {
-	obj_bytes, err := dt.GetObjectBytes(id)
-	
-	if err != nil {
-		return err
-	} else if obj_bytes != nil {
-		err = json.Unmarshal(obj_bytes, pObj)
-		if err != nil {
-			return err
-		}
-	}
-	
-	return nil
-}
-
-func (dt DocTable) SaveObject(id string, obj interface{}) error {
-	obj_bytes, err := json.Marshal(obj)
-	if err != nil {
-		return err
-	}
-
-	err = dt.stub.PutState(dt.composeKey(id), obj_bytes)
-	if err != nil {
-		return err
-	}
-	
-	return nil
-}
-
-
-func (dt DocTable) IsObjectExist(id string) (bool, error) {
-	obj_bytes, err := dt.GetObjectBytes(id)
-	
-	if err != nil {
-		return false, err
-	} else if obj_bytes == nil {
-		return false, nil
-	}
-	
-	return true, nil
-}
-
-func (dt DocTable) IsTableExist() bool {
-	_, exist := SF_TABLES[dt.Name]
-	return exist
-}
-
-// chaincode response结构
-type chaincodeRet struct {
-	Code int    // 0 success otherwise 1
-	Description  string //description
-}
-
 //SupplyFinance chaincode基本结构
 type SupplyFinance struct {
 }
@@ -410,1259 +433,957 @@ func (bsi BillSplitInfoArg) SumAmountOfChildBill() float64{
 	return sum
 }
 
-// response 格式化消息
-func getRetByte(code int, des string) []byte {
-	var r chaincodeRet
-	r.Code = code
-	r.Description = des
 
-	b, err := json.Marshal(r)
+// pObj: pointer to individual object
+func NewObjectFromJsonString(jsonStr string, pObj interface{}) error {
+	// 不认识的字段报错，避免字段名拼写错误时被当作零值
+	dec := json.NewDecoder(strings.NewReader(jsonStr))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(pObj)
 
 	if err != nil {
-		fmt.Println("marshal Ret failed")
-		return nil
+		return newError(ErrInvalidArg, "unmarshal argument failed: %s", err.Error())
 	}
-	return b
-}
-
-// response
-func getRetString(code int, des string) string {
-	var r chaincodeRet
-	r.Code = code
-	r.Description = des
-
-	b, err := json.Marshal(r)
 
-	if err != nil {
-		fmt.Println("marshal Ret failed")
-		return ""
-	}
-	return string(b[:])
+	// 按结构体sf标签声明的规则校验
+	return validateArg(pObj)
 }
 
-// pObj: pointer to individual object
-func NewObjectFromJsonString(jsonStr string, pObj interface{}) error {
-	err := json.Unmarshal([]byte(jsonStr), pObj)
-
+//Init chaincode基本接口，实例化或升级时初始化链码配置
+//  args: 0 - {Config Object}，可选，不传时保留已有配置，没有配置则保存默认配置
+func (sfb *SupplyFinance) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	cfg, err := initConfig(stub, args)
 	if err != nil {
-		return err
+		return retError(err)
 	}
-	
-	return nil
-}
 
-//Init chaincode基本接口
-func (sfb *SupplyFinance) Init(stub shim.ChaincodeStubInterface) pb.Response {
-	return shim.Success(nil)
+	return retSuccess("init success", cfg)
 }
 
 //Invoke chaincode基本接口
 func (sfb *SupplyFinance) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
-	if function == "issueBill" {
-		// 由合同关联或拆分票据而产生票据
-		return sfb.issueBill(stub, args)
-	} else if function == "endorseBill" {
-		// 核心企业同意担保票据
-		return sfb.endorseBill(stub, args)
-	} else if function == "rejectBill" {
-		// 核心企业拒绝担保票据
-		return sfb.rejectBill(stub, args)
-	} else if function == "issueContract" {
-		// 上传并生成合同
-		return sfb.issueContract(stub, args)
-	} else if function == "endorseContract" {
-		// 核心企业同意担保合同
-		return sfb.endorseContract(stub, args)
-	} else if function == "rejectContract" {
-		// 核心企业拒绝担保合同
-		return sfb.rejectContract(stub, args)
-	} else if function == "transferBill" {
-		// 流转票据
-		return sfb.transferBill(stub, args)
-	} else if function == "redeemBill" {
-		// 已还款，赎回票据
-		return sfb.redeemBill(stub, args)
-	} else if function == "abolishBill" {
-		// 票据持有人废弃票据
-		return sfb.abolishBill(stub, args)
-	} else if function == "splitBill" {
-		// 票据持有人拆分票据
-		return sfb.splitBill(stub, args)
-	} else if function == "applyGuarantee" {
-		// 申请贷款前，需要信用企业先担保贷款
-		return sfb.applyGuarantee(stub, args)
-	} else if function == "endorseLoan" {
-		// 核心企业同意为供应商贷款担保 
-		return sfb.endorseLoan(stub, args)
-	} else if function == "rejectLoan" {
-		// 核心企业拒绝为供应商贷款担保 
-		return sfb.rejectLoan(stub, args)
-	} else if function == "applyLoanAfterGuarantee" {
-		// 担保成功后，票据持有人继续申请贷款
-		return sfb.applyLoanAfterGuarantee(stub, args)
-	} else if function == "applyLoan" {
-		// 票据持有人申请贷款
-		return sfb.applyLoan(stub, args)
-	} else if function == "refuseLoan" {
-		// 金融机构拒绝给票据持有人贷款
-		return sfb.refuseLoan(stub, args)
-	} else if function == "approveLoan" {
-		// 金融机构同意给票据持有人贷款
-		return sfb.approveLoan(stub, args)
-	} else if function == "makeLoan" {
-		// 金融机构同意贷款后放贷
-		return sfb.makeLoan(stub, args)
-	} else if function == "prepayLoan" {
-		// 贷款人申请提前还款
-		return sfb.prepayLoan(stub, args)
-	} else if function == "repayLoan" {
-		// 贷款人还款
-		return sfb.repayLoan(stub, args)
-	} else if function == "queryBillChilds" {
-		// 查询票据拆分后的子票据ID集合
-		return sfb.queryBillChilds(stub, args)
-	} else if function == "queryByID" {
-		// 按唯一ID查询单条记录
-		return sfb.queryByID(stub, args)
-	} else if function == "queryAll" {
-		// 按条件查询多条记录
-		return sfb.queryAll(stub, args)
-	} else if function == "queryBillsWithPagination" {
-		// 按条件分页查询
-		return sfb.queryBillsWithPagination(stub, args)
-	} else if function == "queryTXChainForKey" {
-		// 查询票据或贷款的交易历史
-		return sfb.queryTXChainForKey(stub, args)
-	}
-
-	res := getRetString(1, "Chaincode Unkown method!")
-
-	return shim.Error(res)
+	// 函数在routes.go中注册，由路由检查参数和调用者角色后调用
+	return sfb.dispatch(stub, function, args)
 }
 
 //issueContract 上传并生成合同信息
 // args: 0 - {Contract Object}
-func (sfb *SupplyFinance) issueContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		res := getRetString(1, "Chaincode Invoke issue contract args != 1")
-		return shim.Error(res)
-	}
+func (sfb *SupplyFinance) issueContract(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	ct := *args.Object(0).(*Contract)
 
-	var ct Contract
-	err := NewObjectFromJsonString(args[0], &ct)
+	err := sfb.issueContractObj(stub, &ct, ContractUploaded)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke issueContract failed: %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	}
-
-	msg, ok := sfb.issueContractObj(stub, &ct, ContractUploaded)
-	if !ok {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+		return nil, err
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
+	return ct, nil
 }
 
-func (sfb *SupplyFinance) issueContractObj(stub shim.ChaincodeStubInterface, ct *Contract, init_state string) (string, bool){
-	// ID唯一
-	dt := DocTable{"contract", stub}
-	
-	exist, err := dt.IsObjectExist(ct.ContractID)
+func (sfb *SupplyFinance) issueContractObj(stub shim.ChaincodeStubInterface, ct *Contract, init_state string) error {
+	// 金额单位须为配置允许的币种
+	cfg, err := loadConfig(stub)
 	if err != nil {
-		return err.Error(), false
-	} else if exist {
-		res := fmt.Sprintf("Chaincode Invoke issueContractObj failed : the bill has existting, bill NO: %s", ct.ContractID)
-		return res, false
+		return err
 	}
 
-	// 设置状态
+	err = cfg.checkCurrency(ct.AmountUnit)
+	if err != nil {
+		return err
+	}
+
+	// 设置状态及版本
 	ct.State = init_state
+	ct.Version = 1
+	ct.EndorsedVersion = 0
 
-	// 保存
-	err = dt.SaveObject(ct.ContractID, *ct)
+	// 保存，ID唯一
+	err = NewContractRepo(stub).Create(ct)
 	if err != nil {
-		return err.Error(), false
+		return err
 	}
 
-	return "invoke issue success", true
+	_, err = putContractVersion(stub, ct, nil)
+	return err
 }
 
 func (td TableDataArg) isTableExist() bool {
-	_, exist := SF_TABLES[td.TableName]	
-	return exist
+	_, err := lookupTable(td.TableName)
+	return err == nil
 }
 
-//issueBill 票据发布
-// args: 0 - {TableDataArg Object}
-func (sfb *SupplyFinance) issueBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		res := getRetString(1, "Chaincode Invoke issueBill args != 1")
-		return shim.Error(res)
+//issueBill 票据发布：引用已担保合同(parent_id)的票据直接背书；没有合同的票据为issued状态，等待还款人endorseBill
+// args: 0 - {Bill Object}; 1 - "migrate"，可选，迁移管理员直接生成已背书的票据
+func (sfb *SupplyFinance) issueBill(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	bill := *args.Object(0).(*Bill)
+
+	// 金额单位须为配置允许的币种
+	cfg, err := loadConfig(stub)
+	if err != nil {
+		return nil, err
 	}
 
-/*	var td TableDataArg
-	err := json.Unmarshal([]byte(args[0]), &td)
+	err = cfg.checkCurrency(bill.AmountUnit)
 	if err != nil {
-		res := getRetString(1, "Chaincode Invoke issueBill unmarshal failed")
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	if !td.isTableExist() {
-		res := fmt.Sprintf("Chaincode Invoke issueBill failed, due to table[%s] not exist", td.TableName)
-		res = getRetString(1, res)
-		return shim.Error(res)
+
+	// 数据迁移：直接生成已背书的票据，只有迁移管理员可以调用
+	if args.Len() == 2 && args.String(1) != "" {
+		if args.String(1) != IssueModeMigrate {
+			return nil, newError(ErrInvalidArg, "Chaincode Invoke issueBill failed: unknown issue mode: %s", args.String(1)).With("arg", args.String(1))
+		}
+
+		err = requireRole(stub, RoleMigration)
+		if err != nil {
+			return nil, err
+		}
+
+		err = sfb.issueBillObj(stub, &bill, -1, Endorsed)
+		if err != nil {
+			return nil, err
+		}
+
+		return bill, nil
 	}
-	
-	var bill Bill
-	// 注意：json的key名称包含下划线时，下面的转换会得不到预期的值
-	// 用驼峰格式命名可解决
-	if err := ms.Decode(td.Data, &bill); err != nil {
-		res := getRetString(1, "Chaincode Invoke issueBill failed: unkown bill record format")
-		return shim.Error(res)
+
+	// 没有合同的票据等待还款人通过endorseBill背书
+	if bill.ParentID == "" {
+		err = sfb.issueBillObj(stub, &bill, -1, BillIssued)
+		if err != nil {
+			return nil, err
+		}
+
+		return describe("invoke issueBill success, waiting for the drawee's endorsement", bill), nil
 	}
-*/
 
-	var bill Bill
-	err := NewObjectFromJsonString(args[0], &bill)
+	// 引用已担保的合同，占用合同未生成票据的金额
+	ct, err := NewContractRepo(stub).Get(bill.ParentID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke issueBill failed: %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
 
-	msg, ok := sfb.issueBillObj(stub, &bill, -1, Endorsed)
-	if !ok {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	if ct.OwnerName != bill.OwnerName || ct.Drawee != bill.Drawee {
+		return nil, errForbidden("Chaincode Invoke issueBill failed: the owner or drawee of bill is not same with the contract's")
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
-}
-
-func (sfb *SupplyFinance) issueBillObj(stub shim.ChaincodeStubInterface, bill *Bill, parent_split_count int32, init_state string) (string, bool){
-	// 根据票号 查找是否票号已存在
-	dt := DocTable{"bill", stub}
-	exist, err := dt.IsObjectExist(bill.BillID)
+	b, err := sfb.issueContractBillObj(stub, ct, bill.BillID, bill.Amount, bill.CreateDate)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke issueBillObj failed : %s", err.Error())
-		return res, false
-	} else if exist {
-		res := fmt.Sprintf("Chaincode Invoke issueBillObj failed : the bill has existting, bill NO: %s", bill.BillID)
-		return res, false
+		return nil, err
 	}
 
+	return b, nil
+}
+
+func (sfb *SupplyFinance) issueBillObj(stub shim.ChaincodeStubInterface, bill *Bill, parent_split_count int32, init_state string) error {
 	// 设置票据的状态
 	bill.State = init_state
 
 	// 更新票据拆分次数
 	bill.SplitCount = parent_split_count + 1
 
-	// 保存票据
-	err = dt.SaveObject(bill.BillID, *bill)
-	if err != nil {
-		return err.Error(), false
-	}
-
-	return "invoke issue success", true
+	// 保存票据，票号已存在时失败
+	return NewBillRepo(stub).Create(bill)
 }
 
 //applyLoan 申请贷款
 // args: 0 - {Loan Object}
-func (sfb *SupplyFinance) applyLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	return tryPutApplyLoanObj(stub, args, LoanApplied)
+func (sfb *SupplyFinance) applyLoan(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	ln := *args.Object(0).(*Loan)
+	loan, err := tryPutApplyLoanObj(stub, ln, LoanApplied)
+	if err != nil {
+		return nil, err
+	}
+
+	return loan, nil
 }
 
 //applyGuarantee 申请贷款，但需要信用企业先担保贷款
 // args: 0 - {Loan Object}
-func (sfb *SupplyFinance) applyGuarantee(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	return tryPutApplyLoanObj(stub, args, LoanGurantee)
-}
+func (sfb *SupplyFinance) applyGuarantee(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	ln := *args.Object(0).(*Loan)
+	loan, err := tryPutApplyLoanObj(stub, ln, LoanGurantee)
+	if err != nil {
+		return nil, err
+	}
 
+	return describe("invoke applyLoan success", loan), nil
+}
 
-func tryPutApplyLoanObj(stub shim.ChaincodeStubInterface, args []string, init_state string) pb.Response {
-	if len(args) != 1 {
-		res := getRetString(1, "Chaincode Invoke applyLoan args != 1")
-		return shim.Error(res)
-	}
 
-	var ln Loan
-	err := NewObjectFromJsonString(args[0], &ln)
+func tryPutApplyLoanObj(stub shim.ChaincodeStubInterface, ln Loan, init_state string) (Loan, error) {
+	// 同一票据只能有一个未结束的贷款申请，之前的申请结束后可以用新的贷款编号重新申请
+	loans, err := openLoansOfBill(stub, ln.BillID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke tryPutApplyLoanObj failed: %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return ln, err
 	}
 
-	msg, ok := issueLoanObj(stub, &ln, init_state)
-	if !ok {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	if len(loans) > 0 {
+		res := newError(ErrWrongState, "Chaincode Invoke applyLoan failed: the bill has an open loan application, NO: %s", loans[0].LoanID)
+		return ln, res.With("loan_id", loans[0].LoanID).With("current_state", loans[0].State)
 	}
 
-	msg, ok = tryUpdateBillForLoan(stub, ln.BillID,  Endorsed, BillLoanReady)
-	if !ok {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	err = issueLoanObj(stub, &ln, init_state)
+	if err != nil {
+		return ln, err
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
-}
-
-func issueLoanObj(stub shim.ChaincodeStubInterface, ln *Loan, init_state string) (string, bool){
-	// 根据贷款编号 查找是否贷款申请已存在
-	dt := DocTable{"loan", stub}
-	exist, err := dt.IsObjectExist(ln.LoanID)
+	err = tryUpdateBillForLoan(stub, ln.BillID,  Endorsed, BillLoanReady)
 	if err != nil {
-		return err.Error(), false
-	} else if exist {
-		res := fmt.Sprintf("Chaincode Invoke issueLoanObj failed : the bill has existting, bill NO: %s", ln.LoanID)
-		return res, false
+		return ln, err
 	}
 
+	return ln, nil
+}
+
+func issueLoanObj(stub shim.ChaincodeStubInterface, ln *Loan, init_state string) error {
 	// 设置状态
 	ln.State = init_state
 
-	// 保存
-	err = dt.SaveObject(ln.LoanID, *ln)
-	if err != nil {
-		return err.Error(), false
-	}
-
-	return "invoke success", true
+	// 保存，贷款申请已存在时失败
+	return NewLoanRepo(stub).Create(ln)
 }
 
-func setLoanRepaymentThenPut(stub shim.ChaincodeStubInterface, lr *LoanRepayment, lra *LoanRepaymentArg) (string, bool){
+func setLoanRepaymentThenPut(stub shim.ChaincodeStubInterface, lr *LoanRepayment, lra *LoanRepaymentArg) error {
 	if lra != nil {
 		lr.ActualRepaymentDate = lra.ActualRepaymentDate
-		lr.ActualAmount		   = lra.ActualAmount		
-		lr.AmountUnit	       = lra.AmountUnit	
+		lr.ActualAmount		   = lra.ActualAmount
+		lr.AmountUnit	       = lra.AmountUnit
 		lr.ActualBankRate      = lra.ActualBankRate
-		lr.ActualBankInterest  = lra.ActualBankInterest	
+		lr.ActualBankInterest  = lra.ActualBankInterest
 	}
 
-	dt := DocTable{"loan_repayment", stub}
 	// 保存
-	err := dt.SaveObject(lr.LoanID, *lr)
-	if err != nil {
-		return err.Error(), false
-	}
-	
-	return "invoke success", true
+	return NewLoanRepaymentRepo(stub).Put(lr)
 }
 
 //repayLoan 还贷款
 // args: 0 - {LoanRepaymentArg Object}
-func (sfb *SupplyFinance) repayLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		res := getRetString(1, "Chaincode Invoke repayLoan args count expecting 1")
-		return shim.Error(res)
-	}
+func (sfb *SupplyFinance) repayLoan(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	lra := *args.Object(0).(*LoanRepaymentArg)
 
-	var lra LoanRepaymentArg
-	err := json.Unmarshal([]byte(args[0]), &lra)
-	if err != nil {
-		res := getRetString(1, "Chaincode Invoke repayLoan unmarshal failed")
-		return shim.Error(res)
-	}
-	
-	dt := DocTable{"loan", stub}
-	
-	exist, err := dt.IsObjectExist(lra.LoanID)
+	loan, err := NewLoanRepo(stub).Get(lra.LoanID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke repayLoan failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke repayLoan failed : the loan is not existing, loan NO: %s", lra.LoanID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var loan Loan
-	dt.GetObject(lra.LoanID, &loan)
 
 	if loan.BankName != lra.BankName {
-		res := getRetString(1, "Chaincode Invoke repayLoan failed: guarantor's name is not same with current's")
-		return shim.Error(res)
+		return nil, errForbidden("Chaincode Invoke repayLoan failed: bank's name is not same with current's")
 	}
 
-	dt = DocTable{"loan_repayment", stub}	
-	lr := LoanRepayment{LoanID: loan.LoanID}
-	
-	err = dt.GetObject(loan.LoanID, &lr)
+	// 担保方承担责任后由担保方和贷款人分别还款
+	if ! (loan.ValidateState(LoanLoaned) || loan.ValidateState(LoanCalled)) {
+		return nil, errWrongState("loan", loan.State, LoanLoaned, LoanCalled)
+	}
+
+	lr, err := NewLoanRepaymentRepo(stub).Get(loan.LoanID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke repayLoan failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	lra.AmountUnit = loan.AmountUnit
-	msg, ok2 := setLoanRepaymentThenPut(stub, &lr, &lra)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+
+	// 未传入利息时按配置的计息天数惯例，从放款时间到实际还款时间计算
+	if lra.ActualBankInterest == 0 && lr.MakeLoanDate > 0 {
+		cfg, err := loadConfig(stub)
+		if err != nil {
+			return nil, err
+		}
+
+		if lra.ActualBankRate == 0 {
+			lra.ActualBankRate = loan.BankRate
+		}
+		lra.ActualBankInterest = interest(cfg.DayCount, loan.Amount, lra.ActualBankRate, lr.MakeLoanDate, lra.ActualRepaymentDate)
+		lr.DayCount = cfg.DayCount
 	}
-	
-	msg, ok2 = setLoanStateThenPut(stub, &loan, LoanLoaned, LoanRepaid)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+
+	lra.AmountUnit = loan.AmountUnit
+	if loan.ValidateState(LoanCalled) {
+		lr.RepaidAmount += lra.ActualAmount
 	}
 
-	dt = DocTable{"bill", stub}
-	
-	exist, err = dt.IsObjectExist(loan.BillID)
+	err = setLoanRepaymentThenPut(stub, lr, &lra)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke repayLoan failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke repayLoan failed : the bill is not existing, bill NO: %s", loan.BillID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var bill Bill
-	dt.GetObject(loan.BillID, &bill)
 
-	msg, ok2 = setBillStateThenPut(stub, &bill, BillMorgaged, BillRedeemed)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	// 担保方偿还担保金额、贷款人偿还未覆盖的本金，累计还清本金后贷款才结束
+	if loan.ValidateState(LoanCalled) && lr.RepaidAmount < loan.Amount-AmountEpsilon {
+		return describe("invoke repayLoan success, waiting for the remaining repayment", loan), nil
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
-}
-
-//endorseLoan 担保贷款
-// args: 0 - Loan ID; 1 -Guarantor Name
-func (sfb *SupplyFinance) endorseLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		res := getRetString(1, "Chaincode Invoke endorseLoan args count expecting 2")
-		return shim.Error(res)
+	// 释放授信额度
+	err = releaseCredit(stub, loan)
+	if err != nil {
+		return nil, err
 	}
 
-	loanID := args[0]
-	dt := DocTable{"loan", stub}
-	
-	exist, err := dt.IsObjectExist(loanID)
+	err = setLoanStateThenPut(stub, loan, loan.State, LoanRepaid)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke endorseLoan failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke endorseLoan failed : the loan is not existing, loan NO: %s", loanID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var loan Loan
-	dt.GetObject(loanID, &loan)
 
-	if ! loan.ValidateGuarantorName(args[1]) {
-		res := getRetString(1, "Chaincode Invoke endorseLoan failed: guarantor's name is not same with current's")
-		return shim.Error(res)
+	bill, err := NewBillRepo(stub).Get(loan.BillID)
+	if err != nil {
+		return nil, err
 	}
 
-	msg, ok2 := setLoanStateThenPut(stub, &loan, LoanGurantee, LoanApplied)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	err = setBillStateThenPut(stub, bill, BillMorgaged, BillRedeemed)
+	if err != nil {
+		return nil, err
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
+	return loan, nil
 }
 
-//rejectLoan 担保人拒绝担保贷款
-// args: 0 - Loan ID; 1 -Guarantor Name; 2 - Refuse Reason
-func (sfb *SupplyFinance) rejectLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 3 {
-		res := getRetString(1, "Chaincode Invoke rejectLoan args count expecting 3")
-		return shim.Error(res)
+//endorseLoan 担保贷款，同时生成担保合同
+// args: 0 - Loan ID; 1 -Guarantor Name; 2 - {GuaranteeTermsArg object}，可选
+func (sfb *SupplyFinance) endorseLoan(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	var terms GuaranteeTermsArg
+	if args.Has(2) {
+		terms = *args.Object(2).(*GuaranteeTermsArg)
 	}
 
-	loanID := args[0]
-	dt := DocTable{"loan", stub}
-	
-	exist, err := dt.IsObjectExist(loanID)
+	loanID := args.String(0)
+	loan, err := NewLoanRepo(stub).Get(loanID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke rejectLoan failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke rejectLoan failed : the loan is not existing, loan NO: %s", loanID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var loan Loan
-	dt.GetObject(loanID, &loan)
 
-	if ! loan.ValidateGuarantorName(args[1]) {
-		res := getRetString(1, "Chaincode Invoke rejectLoan failed: guarantor's name is not same with current's")
-		return shim.Error(res)
+	if ! loan.ValidateGuarantorName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke endorseLoan failed: guarantor's name is not same with current's")
 	}
 
-	loan.RefuseReason = args[2]
-	msg, ok2 := setLoanStateThenPut(stub, &loan, LoanGurantee, Rejected)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	if ! loan.ValidateState(LoanGurantee) {
+		return nil, errWrongState("loan", loan.State, LoanGurantee)
 	}
 
-	// TODO: 需不需修改票据状态为endorsed?
-
-	res := getRetByte(0, msg)
-	return shim.Success(res)
-}
+	gt, err := issueGuarantee(stub, loan, terms)
+	if err != nil {
+		return nil, err
+	}
 
-//refuseLoan 金融机构拒绝贷款 
-// args: 0 - {LoanResultArg object}
-func (sfb *SupplyFinance) refuseLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		res := getRetString(1, "Chaincode Invoke refuseLoan args != 1")
-		return shim.Error(res)
+	err = setLoanStateThenPut(stub, loan, LoanGurantee, LoanApplied)
+	if err != nil {
+		return nil, err
 	}
 
-	var lr LoanResultArg
-	err := json.Unmarshal([]byte(args[0]), &lr)
+	return map[string]interface{}{"loan": loan, "guarantee": gt}, nil
+}
 
+//rejectLoan 担保人拒绝担保贷款
+// args: 0 - Loan ID; 1 -Guarantor Name; 2 - Refuse Reason
+func (sfb *SupplyFinance) rejectLoan(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	loanID := args.String(0)
+	loan, err := NewLoanRepo(stub).Get(loanID)
 	if err != nil {
-		res := getRetString(1, "Chaincode Invoke refuseLoan unmarshal failed")
-		return shim.Error(res)
+		return nil, err
 	}
 
-	loanID := lr.LoanID
-	dt := DocTable{"loan", stub}
-	
-	exist, err := dt.IsObjectExist(loanID)
+	if ! loan.ValidateGuarantorName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke rejectLoan failed: guarantor's name is not same with current's")
+	}
+
+	loan.RefuseReason = args.String(2)
+	err = setLoanStateThenPut(stub, loan, LoanGurantee, Rejected)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke refuseLoan failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke refuseLoan failed : the loan is not existing, loan NO: %s", loanID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var loan Loan
-	dt.GetObject(loanID, &loan)
 
-	if loan.OwnerName != lr.OwnerName {
-		res := getRetString(1, "Chaincode Invoke refuseLoan failed: owner's name is not same with current's")
-		return shim.Error(res)
+	// 释放票据，贷款人可以重新申请
+	err = releaseBillForLoan(stub, loan.BillID)
+	if err != nil {
+		return nil, err
 	}
 
-	loan.Bank = lr.Bank
-	loan.BankName = lr.BankName
-	loan.RefuseReason = lr.RefuseReason
+	return loan, nil
+}
 
-	msg, ok2 := setLoanStateThenPut(stub, &loan, LoanApplied, LoanRefused)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+//withdrawLoan 贷款人撤回未结束的贷款申请，票据恢复为endorsed
+// args: 0 - Loan ID; 1 - Owner Name
+func (sfb *SupplyFinance) withdrawLoan(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	loanID := args.String(0)
+	loan, err := NewLoanRepo(stub).Get(loanID)
+	if err != nil {
+		return nil, err
 	}
 
-	msg, ok2 = tryUpdateBillForLoan(stub, loan.BillID, BillLoanReady, Endorsed)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	if ! loan.ValidateOwnerName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke withdrawLoan failed: owner's name is not same with current's")
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
-}
-
-//makeLoan 金融机构同意贷款后放贷
-// args: 0 - Loan ID; 1 -Bank Name; 2 - MakeLoan Date
-func (sfb *SupplyFinance) makeLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 3 {
-		res := getRetString(1, "Chaincode Invoke makeLoan args count expecting 3")
-		return shim.Error(res)
+	// 金融机构审批前可以撤回
+	if ! openLoanStates[loan.State] {
+		return nil, errWrongState("loan", loan.State, LoanGurantee, Endorsed, LoanApplied)
 	}
 
-	loanID := args[0]
-	dt := DocTable{"loan", stub}
-	
-	exist, err := dt.IsObjectExist(loanID)
+	err = setLoanStateThenPut(stub, loan, loan.State, LoanWithdrawn)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke makeLoan failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke makeLoan failed : the loan is not existing, loan NO: %s", loanID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var loan Loan
-	dt.GetObject(loanID, &loan)
 
-	if ! loan.ValidateBankName(args[1]) {
-		res := getRetString(1, "Chaincode Invoke makeLoan failed: bank's name is not same with current's")
-		return shim.Error(res)
+	_, err = closeLoanOffers(stub, loan.LoanID, "")
+	if err != nil {
+		return nil, err
 	}
 
-	dt = DocTable{"loan_repayment", stub}	
-	lr := LoanRepayment{LoanID: loan.LoanID}
-	
-	err = dt.GetObject(loan.LoanID, &lr)
+	err = releaseBillForLoan(stub, loan.BillID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke makeLoan failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	makeLoanDate, err := strconv.ParseInt(args[2], 10, 64)
-		if err != nil {
-		return shim.Error("Chaincode Invoke makeLoan failed, due to string convert to int64")
+
+	return loan, nil
+}
+
+//refuseLoan 金融机构拒绝贷款，同时拒绝该金融机构自己的报价；其他金融机构还有有效报价时贷款申请继续等待；金融机构须登记在调用者组织下
+// args: 0 - {LoanResultArg object}
+func (sfb *SupplyFinance) refuseLoan(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	lr := *args.Object(0).(*LoanResultArg)
+
+	loanID := lr.LoanID
+	loan, err := NewLoanRepo(stub).Get(loanID)
+	if err != nil {
+		return nil, err
 	}
-	lr.makeLoanDate = makeLoanDate
-	
-	msg, ok2 := setLoanRepaymentThenPut(stub, &lr, nil)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+
+	if loan.OwnerName != lr.OwnerName {
+		return nil, errForbidden("Chaincode Invoke refuseLoan failed: owner's name is not same with current's")
 	}
-	
-	msg, ok2 = setLoanStateThenPut(stub, &loan, LoanApproved, LoanLoaned)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+
+	// 金融机构须登记在调用者组织下
+	err = requireParticipant(stub, lr.Bank)
+	if err != nil {
+		return nil, err
+	}
+
+	if ! loan.ValidateState(LoanApplied) {
+		return nil, errWrongState("loan", loan.State, LoanApplied)
+	}
+
+	waiting, err := refuseLoanOffer(stub, loan.LoanID, lr.Bank)
+	if err != nil {
+		return nil, err
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
+	if waiting {
+		return describe("invoke refuseLoan success, waiting for other offers", loan), nil
+	}
+
+	// 释放可能占用的授信额度
+	err = releaseCredit(stub, loan)
+	if err != nil {
+		return nil, err
+	}
+
+	loan.RefuseReason = lr.RefuseReason
+	err = setLoanStateThenPut(stub, loan, LoanApplied, LoanRefused)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = closeLoanOffers(stub, loan.LoanID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = releaseBillForLoan(stub, loan.BillID)
+	if err != nil {
+		return nil, err
+	}
+
+	return loan, nil
 }
 
-//approveLoan 金融机构同意贷款
-// args: 0 - {LoanResultArg object}
-func (sfb *SupplyFinance) approveLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		res := getRetString(1, "Chaincode Invoke approveLoan args != 1")
-		return shim.Error(res)
+//makeLoan 金融机构同意贷款后放贷
+// args: 0 - Loan ID; 1 -Bank Name; 2 - MakeLoan Date
+func (sfb *SupplyFinance) makeLoan(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	loanID := args.String(0)
+	loan, err := NewLoanRepo(stub).Get(loanID)
+	if err != nil {
+		return nil, err
 	}
 
-	var lr LoanResultArg
-	err := json.Unmarshal([]byte(args[0]), &lr)
+	if ! loan.ValidateBankName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke makeLoan failed: bank's name is not same with current's")
+	}
 
+	lr, err := NewLoanRepaymentRepo(stub).Get(loan.LoanID)
 	if err != nil {
-		res := getRetString(1, "Chaincode Invoke approveLoan unmarshal failed")
-		return shim.Error(res)
+		return nil, err
 	}
 
-	loanID := lr.LoanID
-	dt := DocTable{"loan", stub}
-	
-	exist, err := dt.IsObjectExist(loanID)
+	lr.MakeLoanDate = args.Int(2)
+
+	err = setLoanRepaymentThenPut(stub, lr, nil)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke approveLoan failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke approveLoan failed : the loan is not existing, loan NO: %s", loanID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var loan Loan
-	dt.GetObject(loanID, &loan)
 
-	if ! loan.ValidateOwnerName(lr.OwnerName) {
-		res := getRetString(1, "Chaincode Invoke approveLoan failed: owner's name is not same with current's")
-		return shim.Error(res)
+	err = setLoanStateThenPut(stub, loan, LoanApproved, LoanLoaned)
+	if err != nil {
+		return nil, err
 	}
 
-	loan.Bank = lr.Bank
-	loan.BankName = lr.BankName
+	return loan, nil
+}
 
-	msg, ok2 := setLoanStateThenPut(stub, &loan, LoanApplied, LoanApproved)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+// 贷款审批通过：贷款状态改为approved，票据抵押，生成还款信息
+func approveLoanObj(stub shim.ChaincodeStubInterface, loan *Loan) error {
+	if ! loan.ValidateState(LoanApplied) {
+		return errWrongState("loan", loan.State, LoanApplied)
 	}
 
-	msg, ok2 = tryUpdateBillForLoan(stub, loan.BillID, BillLoanReady, BillMorgaged)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	// 占用授信额度
+	err := useCredit(stub, loan)
+	if err != nil {
+		return err
 	}
-	
-	lrp := LoanRepayment{LoanID: loan.LoanID, IsPrepayment: false}
-	
-	msg, ok2 = setLoanRepaymentThenPut(stub, &lrp, nil)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+
+	err = bindGuarantee(stub, loan)
+	if err != nil {
+		return err
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
+	err = setLoanStateThenPut(stub, loan, LoanApplied, LoanApproved)
+	if err != nil {
+		return err
+	}
+
+	err = tryUpdateBillForLoan(stub, loan.BillID, BillLoanReady, BillMorgaged)
+	if err != nil {
+		return err
+	}
+
+	lrp := LoanRepayment{LoanID: loan.LoanID, IsPrepayment: false}
+
+	return setLoanRepaymentThenPut(stub, &lrp, nil)
 }
 
 //prepayLoan 提前还款
 // args: 0 - Loan ID; 1 - Owner Name;
-func (sfb *SupplyFinance) prepayLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		res := getRetString(1, "Chaincode Invoke prepayLoan args != 2")
-		return shim.Error(res)
-	}
-
-	loanID := args[0]
-	dt := DocTable{"loan", stub}
-	
-	exist, err := dt.IsObjectExist(loanID)
+func (sfb *SupplyFinance) prepayLoan(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	loanID := args.String(0)
+	loan, err := NewLoanRepo(stub).Get(loanID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke prepayLoan failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke prepayLoan failed : the loan is not existing, loan NO: %s", loanID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var loan Loan
-	dt.GetObject(loanID, &loan)
-	
-	if ! loan.ValidateOwnerName(args[1]) {
-		res := getRetString(1, "Chaincode Invoke prepayLoan failed: loan owner's name is not same with current's")
-		return shim.Error(res)
+
+	if ! loan.ValidateOwnerName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke prepayLoan failed: loan owner's name is not same with current's")
 	}
-	
+
 	if ! loan.ValidateState(LoanApproved) && ! loan.ValidateState(LoanLoaned) {
-		res := getRetString(1, "Chaincode Invoke prepayLoan failed: loan's state is not expected status, needed: 'approved' or 'loaned'")
-		return shim.Error(res)
+		return nil, errWrongState("loan", loan.State, LoanApproved, LoanLoaned)
 	}
-	
-	dt = DocTable{"loan_repayment", stub}	
-	lr := LoanRepayment{LoanID: loan.LoanID}
-	
-	err = dt.GetObject(loan.LoanID, &lr)
+
+	lr, err := NewLoanRepaymentRepo(stub).Get(loan.LoanID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke prepayLoan failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
+
 	if lr.IsPrepayment {
-		res := fmt.Sprintf("Chaincode Invoke prepayLoan failed: The loan has been applied prepayment, loan NO: %s", loan.LoanID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		res := newError(ErrDuplicate, "Chaincode Invoke prepayLoan failed: The loan has been applied prepayment, loan NO: %s", loan.LoanID)
+		return nil, res.With("id", loan.LoanID)
 	}
-	
+
 	lr.IsPrepayment = true
-	msg, ok2 := setLoanRepaymentThenPut(stub, &lr, nil)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	err = setLoanRepaymentThenPut(stub, lr, nil)
+	if err != nil {
+		return nil, err
 	}
-	
-	res := getRetByte(0, msg)
-	return shim.Success(res)
+
+	return lr, nil
 }
 
 //applyLoanAfterGuarantee 贷款担保成功后，继续申请贷款
 // args: 0 - Loan ID ; 1 - Owner Name
-func (sfb *SupplyFinance) applyLoanAfterGuarantee(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		res := getRetString(1, "Chaincode Invoke applyLoanAfterGuarantee args count expecting 2")
-		return shim.Error(res)
-	}
-
-	loanID := args[0]
-	dt := DocTable{"loan", stub}
-	
-	exist, err := dt.IsObjectExist(loanID)
+func (sfb *SupplyFinance) applyLoanAfterGuarantee(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	loanID := args.String(0)
+	loan, err := NewLoanRepo(stub).Get(loanID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke applyLoanAfterGuarantee failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke applyLoanAfterGuarantee failed : the loan is not existing, loan NO: %s", loanID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var loan Loan
-	dt.GetObject(loanID, &loan)
-	
-	if ! loan.ValidateOwnerName(args[1]) {
-		res := getRetString(1, "Chaincode Invoke applyLoanAfterGuarantee failed: owner's name is not same with current's")
-		return shim.Error(res)
+
+	if ! loan.ValidateOwnerName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke applyLoanAfterGuarantee failed: owner's name is not same with current's")
 	}
 
-	msg, ok2 := setLoanStateThenPut(stub, &loan, Endorsed, LoanApplied)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	err = setLoanStateThenPut(stub, loan, Endorsed, LoanApplied)
+	if err != nil {
+		return nil, err
 	}
-	return shim.Success(nil)
+
+	return loan, nil
 }
 
 
-func setLoanStateThenPut(stub shim.ChaincodeStubInterface, loan *Loan, expected_state, set_state string) (string, bool){
+func setLoanStateThenPut(stub shim.ChaincodeStubInterface, loan *Loan, expected_state, set_state string) error {
 	// 检查票据当前状态
 	if loan.State != expected_state {
-		res := fmt.Sprintf("Chaincode Invoke failed: due to loan's state, current state: %s", loan.State)
-		return res, false
+		return errWrongState("loan", loan.State, expected_state)
 	}
 
 	// 更改票据状态
 	loan.State = set_state
 
-	dt := DocTable{"loan", stub}
+	// 贷款结束时解除担保
+	if loanClosedStates[set_state] {
+		err := releaseGuarantee(stub, loan)
+		if err != nil {
+			return err
+		}
+	}
+
 	// 保存
-	err := dt.SaveObject(loan.LoanID, *loan)
+	return NewLoanRepo(stub).Put(loan)
+}
+
+//endorseContract 担保合同，担保的版本须为合同当前版本；还款人设置了审批策略时，签名数达到门限才担保；Bill ID不为空时按合同全额生成票据，为空时之后通过issueContractBill分批生成
+//  args: 0 - Contract_No ; 1 - Drawee Name ; 2 - Bill ID ; 3 - Bill Created Date ; 4 - Contract Version ;
+func (sfb *SupplyFinance) endorseContract(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	contractID := args.String(0)
+	ct, err := NewContractRepo(stub).Get(contractID)
 	if err != nil {
-		return err.Error(), false
+		return nil, err
 	}
 
-	return "invoke success", true
-}
+	if ! ct.ValidateDraweeName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke endorseContract failed: Endorser is not same with current drawee")
+	}
 
-//endorseContract 担保合同
-//  args: 0 - Contract_No ; 1 - Drawee Name ; 2 - Bill ID ; 3 - Bill Created Date ;
-func (sfb *SupplyFinance) endorseContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 4 {
-		res := getRetString(1, "Chaincode Invoke endorse args count expecting 4")
-		return shim.Error(res)
+	createDate := args.Int(3)
+	version := args.Int(4)
+
+	// 合同在还款人查看后被修改时拒绝担保
+	if version != int64(ct.currentVersion()) {
+		res := newError(ErrWrongState, "Chaincode Invoke endorseContract failed: the contract has been amended, current version: %d", ct.currentVersion())
+		return nil, res.With("ct_version", ct.currentVersion()).With("endorse_version", version)
 	}
-	
-	contractID := args[0]
-	dt := DocTable{"contract", stub}
-	
-	exist, err := dt.IsObjectExist(contractID)
+
+	if ct.State != ContractUploaded {
+		return nil, errWrongState("contract", ct.State, ContractUploaded)
+	}
+
+	// 还款人设置了审批策略时，签名数达到门限才担保
+	apv, approved, err := signApproval(stub, ApproveContract, ct.ContractID, ct.currentVersion(), ct.Drawee)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke endorseContract failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke endorseContract failed : the contract is not existing, contract NO: %s", contractID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var ct Contract
-	dt.GetObject(contractID, &ct)
-
-	if ! ct.ValidateDraweeName(args[1]) {
-		res := getRetString(1, "Chaincode Invoke endorseContract failed: Endorser is not same with current drawee")
-		return shim.Error(res)
-	}
-
-	msg, ok2 := setContractStateThenPut(stub, &ct, ContractUploaded, Endorsed)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
-
-	}
-
-	var bill Bill
-	bill.ParentID = ct.ContractID
-	bill.BillID = args[2]
-	bill.Amount = ct.Amount
-	bill.AmountUnit = ct.AmountUnit
-	bill.IssueDate = ct.IssueDate
-	bill.DueDate = ct.DueDate
-	bill.PyeeName = ct.PyeeName
-	bill.PyeeID = ct.PyeeID
-	bill.PyeeAcct = ct.PyeeAcct
-	bill.Drawee = ct.Drawee
-	bill.DraweeName = ct.DraweeName
-	bill.Issuer = ct.Issuer
-	bill.IssuerName = ct.IssuerName
-	bill.Owner = ct.Owner
-	bill.OwnerName = ct.OwnerName
-	
-	createDate, err := strconv.ParseInt(args[3], 10, 64)
+
+	if ! approved {
+		return describe("invoke endorseContract success, waiting for other approvals", map[string]interface{}{"contract": ct, "approval": apv}), nil
+	}
+	ct.EndorsedVersion = int32(version)
+
+	err = setContractStateThenPut(stub, ct, ContractUploaded, Endorsed)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
+	}
+
+	if args.String(2) == "" {
+		return map[string]interface{}{"contract": ct}, nil
 	}
-	bill.CreateDate = createDate
 
-	msg, ok2 = sfb.issueBillObj(stub, &bill, -1, Endorsed)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	bill, err := sfb.issueContractBillObj(stub, ct, args.String(2), ct.Amount, createDate)
+	if err != nil {
+		return nil, err
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
+	return map[string]interface{}{"contract": ct, "bill": bill}, nil
 }
 
-func setContractStateThenPut(stub shim.ChaincodeStubInterface, ct *Contract, expected_state, set_state string) (string, bool){
+func setContractStateThenPut(stub shim.ChaincodeStubInterface, ct *Contract, expected_state, set_state string) error {
 	// 检查票据当前状态
 	if ct.State != expected_state {
-		res := fmt.Sprintf("Chaincode Invoke setContractStateThenPut failed: due to contract's state, current state: %s", ct.State)
-		return res, false
+		return errWrongState("contract", ct.State, expected_state)
 	}
 
 	// 更改票据状态
 	ct.State = set_state
 
-	dt := DocTable{"contract", stub}
 	// 保存
-	err := dt.SaveObject(ct.ContractID, *ct)
-	if err != nil {
-		return err.Error(), false
-	}
-	
-	return "invoke success", true
+	return NewContractRepo(stub).Put(ct)
 }
 
-//transferBill 票据流转
+//transferBill 票据流转：持票人发起流转，接收方通过acceptBillTransfer确认后变更所有者
 //  args: 0 - {Transfer Info Object}
-func (sfb *SupplyFinance) transferBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		res := getRetString(1, "Chaincode Invoke transferBill args count expecting 1")
-		return shim.Error(res)
-	}
-	
-	var ti TransferInfoArg
-	err := json.Unmarshal([]byte(args[0]), &ti)
+func (sfb *SupplyFinance) transferBill(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	ti := *args.Object(0).(*TransferInfoArg)
+
+	bill, to, err := transferBillObj(stub, ti)
 	if err != nil {
-		res := getRetString(1, "Chaincode Invoke transferBill unmarshal failed")
-		return shim.Error(res)
+		return nil, err
 	}
-	
+
+	return describe("Invoke transferBill success", map[string]interface{}{"bill": bill, "offer": to}), nil
+}
+
+// 发起票据流转：票据进入待接收状态并生成流转要约
+func transferBillObj(stub shim.ChaincodeStubInterface, ti TransferInfoArg) (*Bill, *TransferOffer, error) {
 	// 根据票号取得票据
-	dt := DocTable{"bill", stub}
-	
-	exist, err := dt.IsObjectExist(ti.BillID)
+	bill, err := NewBillRepo(stub).Get(ti.BillID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke transferBill failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke transferBill failed : the bill is not existing, bill NO: %s", ti.BillID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, nil, err
 	}
-	
-	var bill Bill
-	dt.GetObject(ti.BillID, &bill)
 
 	if ! bill.ValidateOwnerName(ti.OldOwnerName) {
-		res := getRetString(1, "Chaincode Invoke transferBill failed: the owner of bill is not same with current's")
-		return shim.Error(res)
+		return nil, nil, errForbidden("Chaincode Invoke transferBill failed: the owner of bill is not same with current's")
 	}
-	
+
 	if bill.ValidateOwnerName(ti.NewOwnerName) || bill.ValidateOwner(ti.NewOwner) {
-		res := getRetString(1, "Chaincode Invoke transferBill failed: the bill should not transfer to self")
-		return shim.Error(res)
+		return nil, nil, newError(ErrInvalidArg, "Chaincode Invoke transferBill failed: the bill should not transfer to self")
 	}
-	
+
 	if ! bill.ValidateState(Endorsed) {
-		res := getRetString(1, "Chaincode Invoke transferBill failed: The bill can not be transferred, due to it's state.")
-		return shim.Error(res)
+		return nil, nil, errWrongState("bill", bill.State, Endorsed)
 	}
 
-	// 根据票号取得票据流转信息
-	dt = DocTable{"bill_transfer", stub}	
-	bt := BillTransfer{BillID: ti.BillID, Count: 0, Transfers: make(map[int]TransferInfoArg)}
-	
-	err = dt.GetObject(ti.BillID, &bt)
+	// 票据进入待接收状态，接收方确认后才变更所有者
+	err = setBillStateThenPut(stub, bill, Endorsed, BillTransferring)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke transferBill failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, nil, err
 	}
-	
-	// 保存票据流转信息
+
 	ti.OldOwner = bill.Owner
-	msg, ok2 := setBillTransferThenPut(stub, &bt, ti)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
-	}
-	
-	msg, ok2 = setTransferredBillThenPut(stub, &bill)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
-	}
-	
-		// 更新票据所有者
-	bill.Owner = ti.NewOwner
-	bill.OwnerName = ti.NewOwnerName
-	bill.Transferred = true
-	msg, ok2 = setBillStateThenPut(stub, &bill, Endorsed, Endorsed)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	to, err := putTransferOffer(stub, ti, ti.OfferTTL)
+	if err != nil {
+		return nil, nil, err
 	}
-	
-	res := getRetByte(0, "Invoke transferBill success")
-	return shim.Success(res)
+
+	return bill, to, nil
 }
 
-func setBillTransferThenPut(stub shim.ChaincodeStubInterface, bt *BillTransfer, ti TransferInfoArg) (string, bool){
-	bt.Count += 1
-	bt.Transfers[bt.Count] = ti
+// 记录票据流转：票据的流转链及原持有人流转出、新持有人接收的索引
+func recordBillTransfer(stub shim.ChaincodeStubInterface, ti TransferInfoArg) error {
+	// 根据票号取得票据流转信息
+	bt, err := NewBillTransferRepo(stub).Find(ti.BillID)
+	if err != nil {
+		return err
+	}
 
-	dt := DocTable{"bill_transfer", stub}
-	// 保存
-	err := dt.SaveObject(bt.BillID, *bt)
+	err = setBillTransferThenPut(stub, bt, ti)
 	if err != nil {
-		return err.Error(), false
+		return err
 	}
 
-	return "invoke success", true
+	return putTransferIndexes(stub, ti)
 }
 
-func setTransferredBillThenPut(stub shim.ChaincodeStubInterface, bill *Bill) (string, bool){
-	dt := DocTable{"transferred_bill", stub}	
-	tb := TransferredBill{Owner: bill.Owner}
-	
-	err := dt.GetObject(bill.Owner, &tb)
+func setBillTransferThenPut(stub shim.ChaincodeStubInterface, bt *BillTransfer, ti TransferInfoArg) error {
+	now, err := getTxTimeMillis(stub)
 	if err != nil {
-		return err.Error(), false
+		return err
 	}
-	
-	tb.Bills = append(tb.Bills, bill.BillID)
 
-	dt = DocTable{"transferred_bill", stub}
+	bt.Hops = append(bt.Hops, TransferHop{
+		Seq: len(bt.Hops) + 1,
+		BillID: ti.BillID,
+		OldOwner: ti.OldOwner,
+		OldOwnerName: ti.OldOwnerName,
+		NewOwner: ti.NewOwner,
+		NewOwnerName: ti.NewOwnerName,
+		TxID: stub.GetTxID(),
+		Timestamp: now,
+	})
+
 	// 保存
-	err = dt.SaveObject(tb.Owner, tb)
+	return NewBillTransferRepo(stub).Put(bt)
+}
+
+//redeemBill 赎回票据，还款人到期兑付后票据状态变为已赎回
+//  args: 0 - Bill_No ; 1 - Drawee Name
+func (sfb *SupplyFinance) redeemBill(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	// 根据票号取得票据
+	billID := args.String(0)
+	bill, err := NewBillRepo(stub).Get(billID)
 	if err != nil {
-		return err.Error(), false
+		return nil, err
 	}
 
-	return "invoke success", true
-}
+	if ! bill.ValidateDraweeName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke redeemBill failed: Redeemer is not same with current drawee")
+	}
 
-//redeemBill 赎回票据
-//  args: 0 - Bill_No ; 1 - Drawee Name 
-func (sfb *SupplyFinance) redeemBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	return shim.Success(nil)
+	err = setBillStateThenPut(stub, bill, Endorsed, BillRedeemed)
+	if err != nil {
+		return nil, err
+	}
+
+	return bill, nil
 }
 
-//endorseBill 担保票据
-//  args: 0 - Bill_No ; 1 - Drawee Name 
-func (sfb *SupplyFinance) endorseBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		res := getRetString(1, "Chaincode Invoke endorseBill args count expecting 2")
-		return shim.Error(res)
+//endorseBill 担保票据，还款人设置了审批策略时，签名数达到门限才担保
+//  args: 0 - Bill_No ; 1 - Drawee Name
+func (sfb *SupplyFinance) endorseBill(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	bill, apv, err := endorseBillObj(stub, args.String(0), args.String(1))
+	if err != nil {
+		return nil, err
+	}
+
+	if bill.State != Endorsed {
+		return describe("invoke endorseBill success, waiting for other approvals", map[string]interface{}{"bill": bill, "approval": apv}), nil
 	}
+
+	return bill, nil
+}
+
+// 还款人担保票据，返回票据及审批记录；签名数未达到门限时票据仍为issued
+func endorseBillObj(stub shim.ChaincodeStubInterface, billID, draweeName string) (*Bill, *Approval, error) {
 	// 根据票号取得票据
-	billID := args[0]
-	dt := DocTable{"bill", stub}
-	
-	exist, err := dt.IsObjectExist(billID)
+	bill, err := NewBillRepo(stub).Get(billID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke endorseBill failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke endorseBill failed : the bill is not existing, bill NO: %s", billID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, nil, err
 	}
-	
-	var bill Bill
-	dt.GetObject(billID, &bill)
 
-	if ! bill.ValidateDraweeName(args[1]) {
-		res := getRetString(1, "Chaincode Invoke endorseBill failed: Endorser is not same with current drawee")
-		return shim.Error(res)
+	if ! bill.ValidateDraweeName(draweeName) {
+		return nil, nil, errForbidden("Chaincode Invoke endorseBill failed: Endorser is not same with current drawee")
 	}
 
-	msg, ok2 := setBillStateThenPut(stub, &bill, BillIssued, Endorsed)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	if bill.State != BillIssued {
+		return nil, nil, errWrongState("bill", bill.State, BillIssued)
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
+	// 还款人设置了审批策略时，签名数达到门限才担保
+	apv, approved, err := signApproval(stub, ApproveBill, bill.BillID, 0, bill.Drawee)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ! approved {
+		return bill, apv, nil
+	}
+
+	err = setBillStateThenPut(stub, bill, BillIssued, Endorsed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bill, apv, nil
 }
 
-func setBillStateThenPut(stub shim.ChaincodeStubInterface, bill *Bill, expected_state, set_state string) (string, bool){
+func setBillStateThenPut(stub shim.ChaincodeStubInterface, bill *Bill, expected_state, set_state string) error {
 	// 检查票据当前状态
 	if bill.State != expected_state {
-		res := fmt.Sprintf("Chaincode Invoke setBillStateThenPut failed: due to bill's state, current state: %s", bill.State)
-		return res, false
+		return errWrongState("bill", bill.State, expected_state)
 	}
 
 	// 更改票据状态
 	bill.State = set_state
 
-	dt := DocTable{"bill", stub}
 	// 保存
-	err := dt.SaveObject(bill.BillID, *bill)
-	if err != nil {
-		return err.Error(), false
-	}
-
-	return "invoke success", true
+	return NewBillRepo(stub).Put(bill)
 }
 
 //rejectContract 拒绝担保合同
 //  args: 0 - Contract_No ; 1 - Drawee Name ; 2 - Rejected Reason
-func (sfb *SupplyFinance) rejectContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 3 {
-		res := getRetString(1, "Chaincode Invoke rejectContract args count expecting 3")
-		return shim.Error(res)
-	}
-
-	contractID := args[0]
-	dt := DocTable{"contract", stub}
-	
-	exist, err := dt.IsObjectExist(contractID)
+func (sfb *SupplyFinance) rejectContract(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	contractID := args.String(0)
+	contract, err := NewContractRepo(stub).Get(contractID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke rejectContract failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke rejectContract failed : the contract is not existing, contract NO: %s", contractID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var contract Contract
-	dt.GetObject(contractID, &contract)
 
-	if ! contract.ValidateDraweeName(args[1]) {
-		res := getRetString(1, "Chaincode Invoke rejectContract failed: Endorser is not same with current drawee")
-		return shim.Error(res)
+	if ! contract.ValidateDraweeName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke rejectContract failed: Endorser is not same with current drawee")
 	}
 
-	contract.RefuseReason = args[2]
+	contract.RefuseReason = args.String(2)
 
-	msg, ok2 := setContractStateThenPut(stub, &contract, ContractUploaded, Rejected)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	err = setContractStateThenPut(stub, contract, ContractUploaded, Rejected)
+	if err != nil {
+		return nil, err
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
+	return contract, nil
 }
 
 //rejectBill 拒绝担保票据
-//  args: 0 - Bill_No ; 1 - Drawee Name 
-func (sfb *SupplyFinance) rejectBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		res := getRetString(1, "Chaincode Invoke rejectBill args count expecting 2")
-		return shim.Error(res)
-	}
-
+//  args: 0 - Bill_No ; 1 - Drawee Name
+func (sfb *SupplyFinance) rejectBill(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
 	// 根据票号取得票据
-	billID := args[0]
-	dt := DocTable{"bill", stub}
-	
-	exist, err := dt.IsObjectExist(billID)
+	billID := args.String(0)
+	bill, err := NewBillRepo(stub).Get(billID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke rejectBill failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke rejectBill failed : the bill is not existing, bill NO: %s", billID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var bill Bill
-	dt.GetObject(billID, &bill)
 
-	if ! bill.ValidateDraweeName(args[1]) {
-		res := getRetString(1, "Chaincode Invoke rejectBill failed: Endorser is not same with current drawee")
-		return shim.Error(res)
+	if ! bill.ValidateDraweeName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke rejectBill failed: Endorser is not same with current drawee")
 	}
 
-	msg, ok2 := setBillStateThenPut(stub, &bill, BillIssued, Rejected)
-	if !ok2 {
-		res := getRetString(1, msg)
-		return shim.Error(res)
+	err = setBillStateThenPut(stub, bill, BillIssued, Rejected)
+	if err != nil {
+		return nil, err
 	}
 
-	res := getRetByte(0, msg)
-	return shim.Success(res)
+	return bill, nil
 }
 
-func tryUpdateBillForLoan(stub shim.ChaincodeStubInterface, bill_id string, expected_state, set_state string) (string, bool) {
+func tryUpdateBillForLoan(stub shim.ChaincodeStubInterface, bill_id string, expected_state, set_state string) error {
 	// 根据票号取得票据
-	dt := DocTable{"bill", stub}
-	
-	exist, err := dt.IsObjectExist(bill_id)
+	bill, err := NewBillRepo(stub).Get(bill_id)
 	if err != nil {
-		return err.Error(), false
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke tryUpdateBillForLoan failed : the bill is not existing, bill NO: %s", bill_id)
-		return res, false
+		return wrapError(err)
 	}
-	
-	var bill Bill
-	dt.GetObject(bill_id, &bill)
 
 	if ! bill.ValidateDueDate() {
-		return "Chaincode tryUpdateBillForLoan failed: the bill is expired", false
+		return newError(ErrExpired, "Chaincode tryUpdateBillForLoan failed: the bill is expired").With("id", bill_id).With("due_date", bill.DueDate)
 	}
 
-	return setBillStateThenPut(stub, &bill, expected_state, set_state)
+	return setBillStateThenPut(stub, bill, expected_state, set_state)
 }
 
-//abolishBill 作废票据
-//  args: 0 - Bill_No ; 1 - Owner
-func (sfb *SupplyFinance) abolishBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		res := getRetString(1, "Chaincode Invoke abolishBill args count expecting 2")
-		return shim.Error(res)
+// 贷款申请结束后释放票据：申请抵押中的票据恢复为endorsed，其他状态(如等待会签作废)保持不变
+// 释放不检查票据到期日，过期票据的贷款申请同样可以结束
+func releaseBillForLoan(stub shim.ChaincodeStubInterface, bill_id string) error {
+	bill, err := NewBillRepo(stub).Get(bill_id)
+	if err != nil {
+		return err
+	}
+
+	if ! bill.ValidateState(BillLoanReady) {
+		return nil
 	}
 
+	return setBillStateThenPut(stub, bill, BillLoanReady, Endorsed)
+}
+
+//abolishBill 作废票据，流转过的票据需还款人通过countersignAbolish会签后作废
+//  args: 0 - Bill_No ; 1 - Owner
+func (sfb *SupplyFinance) abolishBill(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
 	// 根据票号取得票据
-	billID := args[0]
-	dt := DocTable{"bill", stub}
-	
-	exist, err := dt.IsObjectExist(billID)
+	billID := args.String(0)
+	bill, err := NewBillRepo(stub).Get(billID)
 	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke abolishBill failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke abolishBill failed : the bill is not existing, bill NO: %s", billID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	var bill Bill
-	dt.GetObject(billID, &bill)
 
-	if ! bill.ValidateOwnerName(args[1]) {
-		res := getRetString(1, "Chaincode Invoke abolishBill failed: owner is not same with current owner")
-		return shim.Error(res)
+	if ! bill.ValidateOwnerName(args.String(1)) {
+		return nil, errForbidden("Chaincode Invoke abolishBill failed: owner is not same with current owner")
 	}
 
-	// 已经抵押贷款和被拆分的票据不运行作废
-	if bill.ValidateState(BillMorgaged) || bill.ValidateState(BillSplit) {
-		res := fmt.Sprintf("Chaincode Invoke abolishBill failed: The bill can not be abolished due to bill's state, current state: %s", bill.State)
-		res = getRetString(1, res)
-		return shim.Error(res)
+	// 已经抵押贷款、被拆分、流转中及已结束的票据不允许作废
+	if ! (bill.ValidateState(BillIssued) || bill.ValidateState(Endorsed) || bill.ValidateState(BillLoanReady)) {
+		return nil, errWrongState("bill", bill.State, BillIssued, Endorsed, BillLoanReady)
 	}
 
-	// 更改票据状态为拒绝背书担保
-	bill.State = BillAbolished
+	// 流转过的票据还款人仍对当前持有人负有兑付义务，需还款人会签
+	if bill.Transferred {
+		bill.State = BillAbolishing
+		err = NewBillRepo(stub).Put(bill)
+		if err != nil {
+			return nil, err
+		}
 
-	// 保存
-	err = dt.SaveObject(billID, bill)
-	if err != nil {
-		return shim.Error(err.Error())
+		return describe("invoke abolishBill success, waiting for the drawee's countersign", map[string]interface{}{"bill": bill}), nil
 	}
 
-	res := getRetByte(0, "invoke accept success")
+	loans, err := abolishBillObj(stub, bill)
+	if err != nil {
+		return nil, err
+	}
 
-	return shim.Success(res)
+	return map[string]interface{}{"bill": bill, "rejected_loans": loans}, nil
 }
 
 /*splitBill 拆分票据
@@ -1688,140 +1409,174 @@ func (sfb *SupplyFinance) abolishBill(stub shim.ChaincodeStubInterface, args []s
 **	]
 **  }
 */
-func (sfb *SupplyFinance) splitBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		res := getRetString(1, "Chaincode Invoke splitBill args count expecting 1")
-		return shim.Error(res)
-	}
-
-	var bsi BillSplitInfoArg
-	err := json.Unmarshal([]byte(args[0]), &bsi)
+func (sfb *SupplyFinance) splitBill(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	bsi := *args.Object(0).(*BillSplitInfoArg)
 
+	b, err := NewBillRepo(stub).Get(bsi.BillID)
 	if err != nil {
-		res := getRetString(1, "Chaincode Invoke splitBill unmarshal failed")
-		return shim.Error(res)
-	}
-
-	var b Bill
-	dt := DocTable{"bill", stub}
-	
-	exist, err := dt.IsObjectExist(bsi.BillID)
-	if err != nil {
-		res := fmt.Sprintf("Chaincode Invoke splitBill failed : %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	} else if !exist {
-		res := fmt.Sprintf("Chaincode Invoke splitBill failed : the bill is not existing, bill NO: %s", bsi.BillID)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
-	
-	dt.GetObject(bsi.BillID, &b)
 
 	if ! b.ValidateOwnerName(bsi.OwnerName) {
-		res := getRetString(1, "Chaincode Invoke splitBill failed: owner is not same with current owner")
-		return shim.Error(res)
+		return nil, errForbidden("Chaincode Invoke splitBill failed: owner is not same with current owner")
 	}
 
-	if ! b.ValidateSplitCount(SplitThreshold) {
-		res := fmt.Sprintf("Chaincode Invoke splitBill failed : the original bill has been spit up to max times, current threshold: %d", SplitThreshold)
-		res = getRetString(1, res)
-		return shim.Error(res)
+	childs, err := sfb.splitBillObj(stub, b, &bsi)
+	if err != nil {
+		return nil, err
 	}
 
+	return map[string]interface{}{"bill": b, "child_bills": childs}, nil
+
+}
+
+// 拆分票据：生成子票据、父票据状态改为split、保存父子关系
+// 子票据的持有人不是父票据持有人时，子票据仍由父票据持有人持有，向该持有人发起流转，接收后变更所有者
+func (sfb *SupplyFinance) splitBillObj(stub shim.ChaincodeStubInterface, b *Bill, bsi *BillSplitInfoArg) ([]Bill, error) {
 	// 只有通过背书担保的票据才能拆分
 	if ! b.ValidateState(Endorsed) {
-		res := fmt.Sprintf("Chaincode Invoke splitBill failed: The bill can not be split due to bill's state, current state: %s", b.State)
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, errWrongState("bill", b.State, Endorsed)
 	}
 
-	sumAmount := bsi.SumAmountOfChildBill()
-	if b.Amount != sumAmount {
-		res := fmt.Sprintf("Chaincode Invoke splitBill failed: The total amount of all child bills is not equal the parent's amount")
-		res = getRetString(1, res)
-		return shim.Error(res)
+	// 按还款人的拆分规则检查拆分深度、子票据数量及金额
+	rule, err := NewSplitRuleRepo(stub).Effective(b.Drawee)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(bsi.Childs) < 2 {
-		res := getRetString(1, "Chaincode Invoke splitBill failed: at least 2 Sub-Bills are required")
-		return shim.Error(res)
+	err = rule.checkSplit(b, bsi)
+	if err != nil {
+		return nil, err
 	}
 
 	child_bills := make([]string, 0)
+	childs := make([]Bill, 0)
 
-	b_child := b
-	b_child.ParentID = bsi.BillID
-	b_child.CreateDate = bsi.SplitDate
 	for _, bc := range bsi.Childs {
+		b_child := *b
+		b_child.ParentID = b.BillID
+		b_child.CreateDate = bsi.SplitDate
 		b_child.BillID= bc.BillID
-		b_child.Owner = bc.Owner
-		b_child.OwnerName = bc.OwnerName
 		b_child.Amount = bc.Amount
 
-		msg, ok := sfb.issueBillObj(stub, &b_child, b.SplitCount, Endorsed)
-		if !ok {
-			res := getRetString(1, msg)
-			return shim.Error(res)
+		if bc.Owner == b.Owner {
+			err = sfb.issueBillObj(stub, &b_child, b.SplitCount, Endorsed)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			err = sfb.issueBillObj(stub, &b_child, b.SplitCount, BillTransferring)
+			if err != nil {
+				return nil, err
+			}
+
+			ti := TransferInfoArg{BillID: bc.BillID, OldOwner: b.Owner, OldOwnerName: b.OwnerName, NewOwner: bc.Owner, NewOwnerName: bc.OwnerName}
+			_, err = putTransferOffer(stub, ti, bsi.OfferTTL)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		child_bills = append(child_bills, bc.BillID)
+		childs = append(childs, b_child)
 	}
 
 	b.State = BillSplit
-	
-	dt = DocTable{"bill", stub}
+
 	// 保存
-	err = dt.SaveObject(b.BillID, b)
+	err = NewBillRepo(stub).Put(b)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putBillChild(stub, b.BillID, child_bills)
+	if err != nil {
+		return nil, err
+	}
+
+	return childs, nil
+}
+
+/*payWithBill 用票据部分支付：从持有的票据中拆出支付金额流转给收款方，剩余金额仍由持票人持有
+**  args: 0 - {PayWithBillArg object json}
+**  sample:
+**  {
+**	"bill_id":"123789",
+**	"owner_name":"国信泰一",
+**	"pay_amount":70000,
+**	"payee":"gt1",
+**	"payee_name":"国泰公司1",
+**	"pay_bill_id":"0001",
+**	"remain_bill_id":"0002",
+**	"pay_date":1577808000000
+**  }
+*/
+func (sfb *SupplyFinance) payWithBill(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	pa := *args.Object(0).(*PayWithBillArg)
+
+	b, err := NewBillRepo(stub).Get(pa.BillID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
 
-	msg, ok := putBillChild(stub, bsi.BillID, child_bills)
-	if !ok {
-		return shim.Error(msg)
+	if ! b.ValidateOwnerName(pa.OwnerName) {
+		return nil, errForbidden("Chaincode Invoke payWithBill failed: owner is not same with current owner")
 	}
 
-	res := getRetByte(0, "invoke endorse success")
-	return shim.Success(res)
+	if b.ValidateOwner(pa.Payee) || b.ValidateOwnerName(pa.PayeeName) {
+		return nil, newError(ErrInvalidArg, "Chaincode Invoke payWithBill failed: the bill should not pay to self")
+	}
+
+	// 全额支付直接用transferBill
+	if pa.Amount <= 0 || pa.Amount >= b.Amount {
+		res := newError(ErrInvalidArg, "Chaincode Invoke payWithBill failed: the pay amount should be greater than 0 and less than the bill's amount")
+		return nil, res.With("pay_amount", pa.Amount).With("amount", b.Amount)
+	}
 
+	bsi := BillSplitInfoArg{
+		BillID: pa.BillID,
+		OwnerName: pa.OwnerName,
+		SplitDate: pa.PayDate,
+		OfferTTL: pa.OfferTTL,
+		Childs: []BillChildArg{
+			{BillID: pa.PayBillID, Owner: pa.Payee, OwnerName: pa.PayeeName, Amount: pa.Amount},
+			{BillID: pa.RemainBillID, Owner: b.Owner, OwnerName: b.OwnerName, Amount: b.Amount - pa.Amount},
+		},
+	}
+
+	childs, err := sfb.splitBillObj(stub, b, &bsi)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"bill": b, "pay_bill": childs[0], "remain_bill": childs[1]}, nil
 }
 
-func putBillChild(stub shim.ChaincodeStubInterface, parent_id string, child_bills []string) (string, bool) {
+func putBillChild(stub shim.ChaincodeStubInterface, parent_id string, child_bills []string) error {
 	var bc BillChild
 	bc.ParentID = parent_id
 	bc.Childs = child_bills
 
-	dt := DocTable{"bill_child", stub}
 	// 保存
-	err := dt.SaveObject(bc.ParentID, bc)
-	if err != nil {
-		return err.Error(), false
-	}
-
-	return "Invoke success", true
+	return NewBillChildRepo(stub).Put(&bc)
 }
 
 //queryMarblesWithPagination 分页查询票据发起人、持有人、还款人的所有票据
 //  0 - Issuer|Drawee|Owner ; 1 - count of page ; 2 - pagination bookmark
-func (t *SupplyFinance) queryBillsWithPagination(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) < 3 {
-		return shim.Error("Chaincode query[queryMarblesWithPagination] failed: argument expecting 3")
-	}
-
-	queryString := args[0]
+func (t *SupplyFinance) queryBillsWithPagination(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	queryString := args.String(0)
 	//return type of ParseInt is int64
-	pageSize, err := strconv.ParseInt(args[1], 10, 32)
-	if err != nil {
-		return shim.Error(err.Error())
+	pageSize := args.Int(1)
+	if pageSize > math.MaxInt32 {
+		return nil, newError(ErrInvalidArg, "page size should be int32: %s", args.String(1)).With("arg", args.String(1))
 	}
-	bookmark := args[2]
+	bookmark := args.String(2)
 
 	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, int32(pageSize), bookmark)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
-	return shim.Success(queryResults)
+	return json.RawMessage(queryResults), nil
 }
 
 func getQueryResultForQueryStringWithPagination(stub shim.ChaincodeStubInterface, queryString string, pageSize int32, bookmark string) ([]byte, error) {
@@ -1860,19 +1615,15 @@ func constructPaginationMetadataToQueryResults(responseMetadata *pb.QueryRespons
 
 //queryAll 返回所有符合条件的记录
 //  0 - {CouchDB selector query}
-func (t *SupplyFinance) queryAll(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *SupplyFinance) queryAll(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
 
-	if len(args) < 1 {
-		return shim.Error("Chaincode query[queryAll] failed: argument expecting 1")
-	}
-
-	queryString := args[0]
+	queryString := args.String(0)
 
 	queryResults, err := getQueryResultForQueryString(stub, queryString)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
-	return shim.Success(queryResults)
+	return json.RawMessage(queryResults), nil
 }
 
 func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString string) ([]byte, error) {
@@ -1937,119 +1688,50 @@ func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorI
 
 //queryTXChainForKey 根据Key查询交易链
 //  0 - Table Name; 1 - ID ;
-func (t *SupplyFinance) queryTXChainForKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		return shim.Error("Chaincode query[queryTXChainForKey] failed: argument expecting 2")
-	}
-
-	table_name := args[0]
-	id := args[1]
-	key := SF_TABLES[table_name] + id
-
-	resultsIterator, err := stub.GetHistoryForKey(key)
+func (t *SupplyFinance) queryTXChainForKey(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	table, err := lookupTable(args.String(0))
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
-	defer resultsIterator.Close()
 
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"TxId\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(response.TxId)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Value\":")
-		if response.IsDelete {
-			buffer.WriteString("null")
-		} else {
-			buffer.WriteString(string(response.Value))
-		}
-
-		buffer.WriteString(", \"Timestamp\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"IsDelete\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(strconv.FormatBool(response.IsDelete))
-		buffer.WriteString("\"")
-
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+	history, err := newStore(stub, table).History(args.String(1))
+	if err != nil {
+		return nil, err
 	}
-	buffer.WriteString("]")
 
-	return shim.Success(buffer.Bytes())
+	return history, nil
 }
 
 // 根据ID查询拆分后的子票据
-// args: 0 - Table Name; 1 - id
-func (sfb *SupplyFinance) queryBillChilds(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		res := getRetString(1, "Chaincode queryBillChilds args != 1")
-		return shim.Error(res)
-	}
-
-	table_name := args[0]
-	id := args[1]
-	key := SF_TABLES[table_name] + id
-	
-	objBytes, err := stub.GetState(key)
-	if  err != nil {
-		res := fmt.Sprintf("Chaincode queryBillChilds failed: %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
-	}
-
-	if objBytes == nil {
-		return shim.Success([]byte("{}"))
+// args: 0 - Bill ID
+func (sfb *SupplyFinance) queryBillChilds(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	bc, err := NewBillChildRepo(stub).Get(args.String(0))
+	if err != nil {
+		return nil, err
 	}
 
-	return shim.Success(objBytes)
+	return bc, nil
 }
 
 // 根据ID查询记录
 // args: 0 - Table Name; 1 - id
-func (sfb *SupplyFinance) queryByID(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		res := getRetString(1, "Chaincode queryByID args != 2")
-		return shim.Error(res)
-	}
-	
-	tableName := args[0]
-	id := args[1]
-	dt := DocTable{tableName, stub}
-	
-	if exist := dt.IsTableExist(); !exist {
-		res := fmt.Sprintf("Chaincode queryByID failed: the table[%s] is not exist.", tableName)
-		res = getRetString(1, res)
-		return shim.Error(res)
+func (sfb *SupplyFinance) queryByID(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	table, err := lookupTable(args.String(0))
+	if err != nil {
+		return nil, err
 	}
 
-	objBytes, err := dt.GetObjectBytes(id)
+	id := args.String(1)
+	objBytes, err := newStore(stub, table).GetBytes(id)
 	if  err != nil {
-		res := fmt.Sprintf("Chaincode queryByID failed: %s", err.Error())
-		res = getRetString(1, res)
-		return shim.Error(res)
+		return nil, err
 	}
 
 	if objBytes == nil {
-		return shim.Success([]byte("{}"))
+		return nil, errNotFound(table.Name, id)
 	}
 
-	return shim.Success(objBytes)
+	return json.RawMessage(objBytes), nil
 }
 
 func main() {
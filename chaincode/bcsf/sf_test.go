@@ -0,0 +1,411 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 一天的毫秒数
+const testDay int64 = 24 * 3600 * 1000
+
+// 测试用的管理员组织，Init时写入配置
+const testAdminMSP = "AdminMSP"
+
+// fabric CA签发证书时写入属性的扩展OID
+var testAttrOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+//testIdentity 测试用的调用者身份
+type testIdentity struct {
+	mspID   string
+	creator []byte // 序列化的SerializedIdentity
+}
+
+// 生成调用者证书，role不为空时写入sf.role属性
+func newIdentity(t *testing.T, mspID, name, role string) *testIdentity {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{mspID}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if role != "" {
+		attrs, _ := json.Marshal(map[string]interface{}{"attrs": map[string]string{RoleAttr: role}})
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: testAttrOID, Value: attrs}}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sid := &msp.SerializedIdentity{Mspid: mspID, IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+	creator, err := proto.Marshal(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testIdentity{mspID: mspID, creator: creator}
+}
+
+//testStub 在MockStub上补充调用者证书、transient和交易时间，fabric 1.4的MockStub不提供这些
+type testStub struct {
+	*shim.MockStub
+	t         *testing.T
+	args      [][]byte
+	creator   []byte
+	transient map[string][]byte
+	now       int64 // 交易时间，毫秒
+	seq       int   // 交易序号，用于生成交易ID
+}
+
+// 生成已初始化的链码，管理员组织为AdminMSP，交易时间为当前时间
+func newTestStub(t *testing.T) *testStub {
+	s := &testStub{MockStub: shim.NewMockStub("bcsf", new(SupplyFinance)), t: t}
+	s.now = time.Now().UnixNano() / int64(time.Millisecond)
+
+	s.as(newIdentity(t, testAdminMSP, "admin", ""))
+	res := s.run(new(SupplyFinance).Init, "init", `{"admin_msps":["`+testAdminMSP+`"],"day_count":"ACT/360","transfer_offer_ttl":604800000}`)
+	mustOK(t, res)
+
+	return s
+}
+
+func (s *testStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *testStub) GetStringArgs() []string {
+	args := make([]string, len(s.args))
+	for i, a := range s.args {
+		args[i] = string(a)
+	}
+
+	return args
+}
+
+func (s *testStub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+
+	return args[0], args[1:]
+}
+
+func (s *testStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *testStub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+func (s *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.now / 1000, Nanos: int32(s.now%1000) * 1000000}, nil
+}
+
+// 之后的调用使用该身份
+func (s *testStub) as(id *testIdentity) *testStub {
+	s.creator = id.creator
+	return s
+}
+
+// 在一个交易中执行fn，args为函数名及参数
+func (s *testStub) run(fn func(shim.ChaincodeStubInterface) pb.Response, args ...string) pb.Response {
+	s.args = make([][]byte, len(args))
+	for i, a := range args {
+		s.args[i] = []byte(a)
+	}
+
+	s.seq++
+	txID := "tx" + strconv.Itoa(s.seq)
+	s.MockTransactionStart(txID)
+	defer s.MockTransactionEnd(txID)
+
+	return fn(s)
+}
+
+// 调用链码函数
+func (s *testStub) invoke(function string, args ...string) pb.Response {
+	return s.run(new(SupplyFinance).Invoke, append([]string{function}, args...)...)
+}
+
+// 直接写入账本记录，模拟升级前保存、没有索引项的记录
+func (s *testStub) putRaw(table tableDef, id string, obj interface{}) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	s.MockTransactionStart("raw")
+	defer s.MockTransactionEnd("raw")
+	if err = s.MockStub.PutState(table.Prefix+id, b); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// 读取账本记录
+func (s *testStub) getRecord(table tableDef, id string, pObj interface{}) {
+	b := s.State[table.Prefix+id]
+	if b == nil {
+		s.t.Fatalf("%s %s is not found", table.Name, id)
+	}
+
+	if err := json.Unmarshal(b, pObj); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// 解析链码返回结构，成功时在Payload中，失败时在Message中
+func parseRet(t *testing.T, res pb.Response) chaincodeRet {
+	var ret chaincodeRet
+	body := res.Payload
+	if res.Status != shim.OK {
+		body = []byte(res.Message)
+	}
+
+	if err := json.Unmarshal(body, &ret); err != nil {
+		t.Fatalf("unmarshal response failed: %s, %s", err, body)
+	}
+
+	return ret
+}
+
+// 要求调用成功
+func mustOK(t *testing.T, res pb.Response) chaincodeRet {
+	t.Helper()
+	if res.Status != shim.OK {
+		t.Fatalf("expect success, got: %s", res.Message)
+	}
+
+	return parseRet(t, res)
+}
+
+// 要求调用失败并返回指定错误码
+func mustFail(t *testing.T, res pb.Response, code string) chaincodeRet {
+	t.Helper()
+	if res.Status == shim.OK {
+		t.Fatalf("expect %s, got success: %s", code, res.Payload)
+	}
+
+	ret := parseRet(t, res)
+	if ret.ErrCode != code {
+		t.Fatalf("expect %s, got %s: %s", code, ret.ErrCode, ret.Description)
+	}
+
+	return ret
+}
+
+// 把返回的Data解析到pObj
+func decodeData(t *testing.T, ret chaincodeRet, pObj interface{}) {
+	t.Helper()
+	b, err := json.Marshal(ret.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = json.Unmarshal(b, pObj); err != nil {
+		t.Fatalf("unmarshal data failed: %s, %s", err, b)
+	}
+}
+
+// 票据参数，到期时间为一年后
+func testBill(billID, drawee, owner string, amount float64) Bill {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return Bill{
+		BillID:     billID,
+		Amount:     amount,
+		AmountUnit: "yuan",
+		IssueDate:  now,
+		DueDate:    now + 365*testDay,
+		PyeeName:   owner + "n",
+		Drawee:     drawee,
+		DraweeName: drawee + "n",
+		Owner:      owner,
+		OwnerName:  owner + "n",
+	}
+}
+
+// 把对象转换为JSON参数
+func toJSON(t *testing.T, obj interface{}) string {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+// 生成还款人已担保的票据，参与方名称为系统账号加"n"
+func (s *testStub) issueEndorsedBill(billID, drawee, owner string, amount float64) {
+	mustOK(s.t, s.invoke("issueBill", toJSON(s.t, testBill(billID, drawee, owner, amount))))
+	mustOK(s.t, s.invoke("endorseBill", billID, drawee+"n"))
+}
+
+// 贷款申请参数，fields为追加的JSON字段，以逗号开头
+func loanArg(loanID, billID, owner string, amount float64, fields string) string {
+	return fmt.Sprintf(`{"loan_id":"%s","ln_bill_id":"%s","ln_amount":%v,"ln_amount_unit":"yuan","ln_owner":"%s","ln_owner_name":"%sn"%s}`, loanID, billID, amount, owner, owner, fields)
+}
+
+// 持票人用票据申请贷款
+func (s *testStub) applyLoan(loanID, billID, owner string, amount float64, fields string) pb.Response {
+	return s.invoke("applyLoan", loanArg(loanID, billID, owner, amount, fields))
+}
+
+// 管理员把参与方登记到id所属的组织
+func (s *testStub) register(participant string, id *testIdentity) {
+	admin := newIdentity(s.t, testAdminMSP, "admin", "")
+	mustOK(s.t, s.as(admin).invoke("registerParticipant", participant, id.mspID))
+}
+
+// 金融机构报价参数，有效期一天
+func (s *testStub) offer(loanID, bank string, amount float64) string {
+	return fmt.Sprintf(`{"lo_loan_id":"%s","lo_bank":"%s","lo_bank_name":"%sn","offer_amount":%v,"offer_rate":0.05,"offer_interest":10,"offer_expire_date":%d}`, loanID, bank, bank, amount, s.now+testDay)
+}
+
+// 金融机构审批结果参数
+func loanResult(loanID, owner, bank string) string {
+	return fmt.Sprintf(`{"loan_id":"%s","ln_owner_name":"%sn","ln_bank":"%s","ln_bank_name":"%sn"}`, loanID, owner, bank, bank)
+}
+
+// 授信额度参数，有效期一年
+func (s *testStub) facility(bank, drawee string, limit float64) string {
+	return fmt.Sprintf(`{"cf_bank":"%s","cf_bank_name":"%sn","cf_drawee":"%s","cf_drawee_name":"%sn","credit_limit":%v,"cf_amount_unit":"yuan","cf_expire_date":%d}`, bank, bank, drawee, drawee, limit, s.now+365*testDay)
+}
+
+// 金融机构报价，贷款人接受报价，返回审批后的贷款；贷款人登记在owner所属的组织
+func (s *testStub) acceptOffer(bank, owner *testIdentity, loanID, ownerAcct, bankAcct string, amount float64) Loan {
+	s.register(ownerAcct, owner)
+	mustOK(s.t, s.as(bank).invoke("submitLoanOffer", s.offer(loanID, bankAcct, amount)))
+
+	var res struct {
+		Loan Loan `json:"loan"`
+	}
+	decodeData(s.t, mustOK(s.t, s.as(owner).invoke("acceptLoanOffer", loanID, ownerAcct+"n", bankAcct)), &res)
+	return res.Loan
+}
+
+func TestWithdrawAndReapplyLoan(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	bank := newIdentity(t, "BankMSP", "bank", RoleBank)
+
+	s.register("bank1", bank)
+	s.as(owner).issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.applyLoan("l1", "b1", "owner", 800, ""))
+	mustOK(t, s.as(bank).invoke("submitLoanOffer", s.offer("l1", "bank1", 800)))
+
+	// 同一票据只能有一个未结束的贷款申请
+	mustFail(t, s.as(owner).applyLoan("l2", "b1", "owner", 800, ""), ErrWrongState)
+
+	mustFail(t, s.invoke("withdrawLoan", "l1", "payeen"), ErrForbidden)
+	var loan Loan
+	decodeData(t, mustOK(t, s.invoke("withdrawLoan", "l1", "ownern")), &loan)
+	if loan.State != LoanWithdrawn {
+		t.Fatalf("unexpected loan state: %s", loan.State)
+	}
+	mustFail(t, s.invoke("withdrawLoan", "l1", "ownern"), ErrWrongState)
+
+	var bill Bill
+	var lo LoanOffer
+	s.getRecord(billTable, "b1", &bill)
+	s.getRecord(loanOfferTable, loanOfferID("l1", "bank1"), &lo)
+	if bill.State != Endorsed || lo.State == OfferPending {
+		t.Fatalf("unexpected states: bill %s, offer %s", bill.State, lo.State)
+	}
+
+	// 撤回后用新的贷款编号重新申请
+	mustFail(t, s.applyLoan("l1", "b1", "owner", 800, ""), ErrDuplicate)
+	mustOK(t, s.applyLoan("l2", "b1", "owner", 800, ""))
+}
+
+func TestReapplyAfterGuarantorRejects(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.issueEndorsedBill("b1", "drawee", "owner", 1000)
+	mustOK(t, s.applyGuarantee("l1", "b1", "owner", 800, 0))
+
+	mustFail(t, s.invoke("rejectLoan", "l1", "ownern", "no"), ErrForbidden)
+	var loan Loan
+	decodeData(t, mustOK(t, s.invoke("rejectLoan", "l1", "draween", "no")), &loan)
+	if loan.State != Rejected || loan.RefuseReason != "no" {
+		t.Fatalf("unexpected loan: %+v", loan)
+	}
+
+	// 担保方拒绝后票据释放
+	var bill Bill
+	s.getRecord(billTable, "b1", &bill)
+	if bill.State != Endorsed {
+		t.Fatalf("unexpected bill state: %s", bill.State)
+	}
+	mustOK(t, s.applyLoan("l2", "b1", "owner", 800, ""))
+}
+
+func TestIssueBillNeedsContractOrDrawee(t *testing.T) {
+	s := newTestStub(t)
+	owner := newIdentity(t, "OwnerMSP", "owner", "")
+	migrator := newIdentity(t, "OwnerMSP", "migrator", RoleMigration)
+
+	// 没有合同的票据等待还款人背书
+	ret := mustOK(t, s.as(owner).invoke("issueBill", toJSON(t, testBill("b1", "drawee", "owner", 1000))))
+	if ret.Description != "invoke issueBill success, waiting for the drawee's endorsement" {
+		t.Fatalf("unexpected description: %s", ret.Description)
+	}
+	mustFail(t, s.invoke("endorseBill", "b1", "ownern"), ErrForbidden)
+	mustOK(t, s.invoke("endorseBill", "b1", "draween"))
+
+	// 只有迁移管理员可以直接生成已背书的票据
+	mustFail(t, s.invoke("issueBill", toJSON(t, testBill("b2", "drawee", "owner", 1000)), IssueModeMigrate), ErrForbidden)
+	mustFail(t, s.as(migrator).invoke("issueBill", toJSON(t, testBill("b2", "drawee", "owner", 1000)), "import"), ErrInvalidArg)
+	var bill Bill
+	decodeData(t, mustOK(t, s.invoke("issueBill", toJSON(t, testBill("b2", "drawee", "owner", 1000)), IssueModeMigrate)), &bill)
+	if bill.State != Endorsed {
+		t.Fatalf("unexpected bill state: %s", bill.State)
+	}
+
+	// 引用合同的票据须在合同未生成票据的金额内
+	s.as(owner)
+	mustOK(t, s.invoke("issueContract", toJSON(t, testContract("c1", "yuan", 1000))))
+	contractBill := func(billID, owner string, amount float64) string {
+		b := testBill(billID, "drawee", owner, amount)
+		b.ParentID = "c1"
+		return toJSON(t, b)
+	}
+	mustFail(t, s.invoke("issueBill", contractBill("b3", "owner", 600)), ErrWrongState)
+	mustOK(t, s.invoke("endorseContract", "c1", "draween", "", "0", "1"))
+
+	mustFail(t, s.invoke("issueBill", contractBill("b3", "payee", 600)), ErrForbidden)
+	mustFail(t, s.invoke("issueBill", contractBill("b3", "owner", 1200)), ErrInvalidArg)
+	decodeData(t, mustOK(t, s.invoke("issueBill", contractBill("b3", "owner", 600))), &bill)
+	if bill.State != Endorsed || bill.ParentID != "c1" {
+		t.Fatalf("unexpected bill: %+v", bill)
+	}
+
+	var ct Contract
+	s.getRecord(contractTable, "c1", &ct)
+	if ct.BilledAmount != 600 {
+		t.Fatalf("unexpected billed amount: %v", ct.BilledAmount)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var splitRuleTable = tableDef{"split_rule", "SPRL_", nil}
+
+func init() {
+	registerTables(splitRuleTable)
+}
+
+// 未配置拆分规则时的默认子票据金额小数位数
+const DefaultAmountPrecision = 2
+
+// 未配置拆分规则时原始票据的默认最大拆分深度/次数
+const DefaultMaxSplitDepth = 1
+
+//SplitRule 核心企业(还款人)的票据拆分规则
+type SplitRule struct {
+	Drawee          string  `json:"sr_drawee"`                    //核心企业系统账号
+	DraweeName      string  `json:"sr_drawee_name"`               //核心企业名称
+	MaxSplitDepth   int32   `json:"max_split_depth"`              //原始票据最大拆分深度/次数
+	MinChildAmount  float64 `json:"min_child_amount"`             //子票据最小金额，0表示不限
+	MaxChilds       int     `json:"max_child_bills"`              //单次拆分最多子票据数，0表示不限
+	AmountPrecision int32   `json:"amount_precision"`             //子票据金额允许的小数位数，子票据金额之和与父票据金额的差不超过半个最小单位
+	UpdateDate      int64   `json:"sr_update_date" sf:"readonly"` //规则修改时间，取交易时间
+}
+
+func (sr SplitRule) recordID() string {
+	return sr.Drawee
+}
+
+func (sr SplitRule) validate() error {
+	if sr.MaxSplitDepth < 1 {
+		return newError(ErrInvalidArg, "max_split_depth should be at least 1").With("max_split_depth", sr.MaxSplitDepth)
+	}
+
+	if sr.MinChildAmount < 0 {
+		return newError(ErrInvalidArg, "min_child_amount should not be negative").With("min_child_amount", sr.MinChildAmount)
+	}
+
+	if sr.MaxChilds != 0 && sr.MaxChilds < 2 {
+		return newError(ErrInvalidArg, "max_child_bills should be 0 or at least 2").With("max_child_bills", sr.MaxChilds)
+	}
+
+	if sr.AmountPrecision < 0 || sr.AmountPrecision > 6 {
+		return newError(ErrInvalidArg, "amount_precision should be between 0 and 6").With("amount_precision", sr.AmountPrecision)
+	}
+
+	return nil
+}
+
+// 子票据金额之和与父票据金额允许的误差
+func (sr SplitRule) roundingTolerance() float64 {
+	return math.Pow10(-int(sr.AmountPrecision)) / 2
+}
+
+// 金额是否符合小数位数要求
+func (sr SplitRule) matchPrecision(amount float64) bool {
+	scaled := amount * math.Pow10(int(sr.AmountPrecision))
+	return math.Abs(scaled-math.Round(scaled)) < AmountEpsilon*math.Pow10(int(sr.AmountPrecision))
+}
+
+// 按规则检查票据拆分参数
+func (sr SplitRule) checkSplit(b *Bill, bsi *BillSplitInfoArg) error {
+	if !b.ValidateSplitCount(sr.MaxSplitDepth) {
+		res := newError(ErrWrongState, "the original bill has been spit up to max times, current threshold: %d", sr.MaxSplitDepth)
+		return res.With("split_count", b.SplitCount).With("threshold", sr.MaxSplitDepth)
+	}
+
+	if len(bsi.Childs) < 2 {
+		return newError(ErrInvalidArg, "at least 2 Sub-Bills are required")
+	}
+
+	if sr.MaxChilds > 0 && len(bsi.Childs) > sr.MaxChilds {
+		return newError(ErrInvalidArg, "too many Sub-Bills, at most %d", sr.MaxChilds).With("max_child_bills", sr.MaxChilds)
+	}
+
+	// 同一交易中读不到之前的写入，子票据号重复时后面的子票据会覆盖前面的
+	seen := make(map[string]bool, len(bsi.Childs))
+	for _, bc := range bsi.Childs {
+		if seen[bc.BillID] {
+			return newError(ErrInvalidArg, "the Sub-Bill NO is duplicated: %s", bc.BillID).With("bill_id", bc.BillID)
+		}
+		seen[bc.BillID] = true
+
+		if bc.Amount <= 0 || bc.Amount < sr.MinChildAmount {
+			res := newError(ErrInvalidArg, "the amount of Sub-Bill is less than the minimum, bill NO: %s", bc.BillID)
+			return res.With("bill_id", bc.BillID).With("amount", bc.Amount).With("min_child_amount", sr.MinChildAmount)
+		}
+
+		if !sr.matchPrecision(bc.Amount) {
+			res := newError(ErrInvalidArg, "the amount of Sub-Bill has too many decimal places, bill NO: %s", bc.BillID)
+			return res.With("bill_id", bc.BillID).With("amount", bc.Amount).With("amount_precision", sr.AmountPrecision)
+		}
+	}
+
+	sumAmount := bsi.SumAmountOfChildBill()
+	if math.Abs(b.Amount-sumAmount) > sr.roundingTolerance()+AmountEpsilon {
+		res := newError(ErrInvalidArg, "The total amount of all child bills is not equal the parent's amount")
+		return res.With("amount", b.Amount).With("sum_of_childs", sumAmount)
+	}
+
+	return nil
+}
+
+//SplitRuleRepo 票据拆分规则表
+type SplitRuleRepo struct {
+	Store
+	stub shim.ChaincodeStubInterface
+}
+
+func NewSplitRuleRepo(stub shim.ChaincodeStubInterface) SplitRuleRepo {
+	return SplitRuleRepo{newStore(stub, splitRuleTable), stub}
+}
+
+//Effective 返回核心企业生效的拆分规则，未配置时返回链码配置中的默认规则
+func (r SplitRuleRepo) Effective(drawee string) (*SplitRule, error) {
+	cfg, err := loadConfig(r.stub)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := cfg.splitRule(drawee)
+	if _, err := r.Store.Find(drawee, &sr); err != nil {
+		return nil, err
+	}
+
+	return &sr, nil
+}
+
+func (r SplitRuleRepo) Put(sr *SplitRule) error {
+	return r.Store.Put(sr.Drawee, *sr)
+}
+
+//setSplitRule 管理员设置核心企业的票据拆分规则，对该企业作为还款人的所有票据生效；管理员由路由检查
+//  args: 0 - {SplitRule Object}
+func (sfb *SupplyFinance) setSplitRule(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	sr := *args.Object(0).(*SplitRule)
+
+	var err error
+	sr.UpdateDate, err = getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = NewSplitRuleRepo(stub).Put(&sr)
+	if err != nil {
+		return nil, err
+	}
+
+	return sr, nil
+}
+
+//querySplitRule 查询核心企业生效的票据拆分规则
+//  args: 0 - Drawee
+func (sfb *SupplyFinance) querySplitRule(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	sr, err := NewSplitRuleRepo(stub).Effective(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	return sr, nil
+}
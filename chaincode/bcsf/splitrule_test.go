@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSetSplitRule(t *testing.T) {
+	s := newTestStub(t)
+	admin := newIdentity(t, testAdminMSP, "admin", "")
+	drawee := newIdentity(t, "DraweeMSP", "drawee", "")
+	rule := `{"sr_drawee":"drawee","sr_drawee_name":"draween","max_split_depth":3,"amount_precision":2}`
+
+	mustFail(t, s.as(drawee).invoke("setSplitRule", rule), ErrForbidden)
+
+	// 修改时间由链码维护
+	mustFail(t, s.as(admin).invoke("setSplitRule", `{"sr_drawee":"drawee","max_split_depth":3,"sr_update_date":1}`), ErrInvalidArg)
+
+	var sr SplitRule
+	decodeData(t, mustOK(t, s.as(admin).invoke("setSplitRule", rule)), &sr)
+	if sr.UpdateDate != s.now || sr.MaxSplitDepth != 3 {
+		t.Fatalf("unexpected split rule: %+v", sr)
+	}
+}
+
+func TestSplitRejectsDuplicateChildIDs(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.issueEndorsedBill("b1", "drawee", "owner", 1000)
+
+	pay := func(payBillID, remainBillID string) string {
+		return fmt.Sprintf(`{"bill_id":"b1","owner_name":"ownern","pay_amount":300,"payee":"payee","payee_name":"payeen","pay_bill_id":"%s","remain_bill_id":"%s","pay_date":%d}`, payBillID, remainBillID, s.now)
+	}
+	mustFail(t, s.invoke("payWithBill", pay("b2", "b2")), ErrInvalidArg)
+
+	split := fmt.Sprintf(`{"bill_id":"b1","owner_name":"ownern","split_date":%d,"child_bills":[{"bill_id":"b2","owner":"owner","owner_name":"ownern","amount":500},{"bill_id":"b2","owner":"owner","owner_name":"ownern","amount":500}]}`, s.now)
+	mustFail(t, s.invoke("splitBill", split), ErrInvalidArg)
+
+	var res struct {
+		PayBill    Bill `json:"pay_bill"`
+		RemainBill Bill `json:"remain_bill"`
+	}
+	decodeData(t, mustOK(t, s.invoke("payWithBill", pay("b2", "b3"))), &res)
+	if res.PayBill.Amount != 300 || res.RemainBill.Amount != 700 {
+		t.Fatalf("unexpected child bills: %+v", res)
+	}
+}
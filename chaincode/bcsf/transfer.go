@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var transferOfferTable = tableDef{"transfer_offer", "TROF_", nil}
+
+func init() {
+	registerTables(transferOfferTable)
+}
+
+// 流转待接收的默认有效期，链码配置transfer_offer_ttl的初始值，7天，单位毫秒
+const DefaultTransferOfferTTL int64 = 7 * 24 * 3600 * THOUSAND
+
+// 票据流转要约状态
+const (
+	OfferPending   = "pending"   // 等待接收方确认
+	OfferAccepted  = "accepted"  // 接收方已接收，票据所有者已变更
+	OfferDeclined  = "declined"  // 接收方拒绝接收
+	OfferCancelled = "cancelled" // 持票人撤回
+	OfferExpired   = "expired"   // 超过有效期未接收，撤回或拒绝时记录为过期
+)
+
+//TransferOffer 票据流转要约，每张票据同时只有一个待接收的要约，历史要约通过queryTXChainForKey查询
+type TransferOffer struct {
+	BillID       string `json:"to_bill_id"`           //票据编号
+	OldOwner     string `json:"old_owner"`            //流转前票据所有者系统账号
+	OldOwnerName string `json:"old_owner_name"`       //流转前票据所有者名称
+	NewOwner     string `json:"new_owner"`            //接收方系统账号
+	NewOwnerName string `json:"new_owner_name"`       //接收方名称
+	State        string `json:"offer_state"`          //要约状态
+	OfferDate    int64  `json:"offer_date"`           //发起流转时间(交易时间)
+	ExpireDate   int64  `json:"expire_date"`          //要约过期时间
+	CloseDate    int64  `json:"close_date,omitempty"` //接收、拒绝或撤回的时间
+}
+
+func (to TransferOffer) recordID() string {
+	return to.BillID
+}
+
+func (to TransferOffer) validate() error {
+	if to.State == "" {
+		return newError(ErrInvalidArg, "the state of transfer offer should not be empty").With("id", to.BillID)
+	}
+
+	return nil
+}
+
+// 要约是否已过期
+func (to TransferOffer) expired(now int64) bool {
+	return now > to.ExpireDate
+}
+
+// 要约对应的流转信息
+func (to TransferOffer) transferInfo() TransferInfoArg {
+	return TransferInfoArg{BillID: to.BillID, OldOwner: to.OldOwner, OldOwnerName: to.OldOwnerName, NewOwner: to.NewOwner, NewOwnerName: to.NewOwnerName}
+}
+
+//TransferOfferRepo 票据流转要约表
+type TransferOfferRepo struct {
+	Store
+}
+
+func NewTransferOfferRepo(stub shim.ChaincodeStubInterface) TransferOfferRepo {
+	return TransferOfferRepo{newStore(stub, transferOfferTable)}
+}
+
+func (r TransferOfferRepo) Get(billID string) (*TransferOffer, error) {
+	var to TransferOffer
+	if err := r.Store.Get(billID, &to); err != nil {
+		return nil, err
+	}
+
+	return &to, nil
+}
+
+func (r TransferOfferRepo) Put(to *TransferOffer) error {
+	return r.Store.Put(to.BillID, *to)
+}
+
+// 发起流转要约，票据须已处于待接收状态，ttl为0时使用链码配置的默认有效期
+func putTransferOffer(stub shim.ChaincodeStubInterface, ti TransferInfoArg, ttl int64) (*TransferOffer, error) {
+	if ttl < 0 {
+		return nil, newError(ErrInvalidArg, "offer_ttl should not be negative").With("offer_ttl", ttl)
+	}
+
+	if ttl == 0 {
+		cfg, err := loadConfig(stub)
+		if err != nil {
+			return nil, err
+		}
+		ttl = cfg.TransferOfferTTL
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	to := TransferOffer{
+		BillID:       ti.BillID,
+		OldOwner:     ti.OldOwner,
+		OldOwnerName: ti.OldOwnerName,
+		NewOwner:     ti.NewOwner,
+		NewOwnerName: ti.NewOwnerName,
+		State:        OfferPending,
+		OfferDate:    now,
+		ExpireDate:   now + ttl,
+	}
+
+	err = NewTransferOfferRepo(stub).Put(&to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &to, nil
+}
+
+// 取得票据待接收的流转要约
+func getPendingOffer(stub shim.ChaincodeStubInterface, billID string) (*TransferOffer, error) {
+	to, err := NewTransferOfferRepo(stub).Get(billID)
+	if err != nil {
+		return nil, err
+	}
+
+	if to.State != OfferPending {
+		return nil, errWrongState("transfer_offer", to.State, OfferPending)
+	}
+
+	return to, nil
+}
+
+// 关闭要约：票据恢复为背书状态，仍由原持有人持有；要约已过期时记录为过期
+func closeTransferOffer(stub shim.ChaincodeStubInterface, to *TransferOffer, state string) (*Bill, error) {
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	bill, err := NewBillRepo(stub).Get(to.BillID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setBillStateThenPut(stub, bill, BillTransferring, Endorsed)
+	if err != nil {
+		return nil, err
+	}
+
+	to.State = state
+	if to.expired(now) {
+		to.State = OfferExpired
+	}
+	to.CloseDate = now
+
+	err = NewTransferOfferRepo(stub).Put(to)
+	if err != nil {
+		return nil, err
+	}
+
+	return bill, nil
+}
+
+//acceptBillTransfer 接收方接收流转的票据，接收后票据所有者变更并记录流转信息
+//  args: 0 - Bill_No ; 1 - New Owner Name
+func (sfb *SupplyFinance) acceptBillTransfer(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	to, err := getPendingOffer(stub, args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if to.NewOwnerName != args.String(1) {
+		return nil, errForbidden("Chaincode Invoke acceptBillTransfer failed: the receiver is not same with the offer's")
+	}
+
+	now, err := getTxTimeMillis(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if to.expired(now) {
+		return nil, newError(ErrExpired, "Chaincode Invoke acceptBillTransfer failed: the offer is expired").With("id", to.BillID).With("expire_date", to.ExpireDate)
+	}
+
+	bill, err := NewBillRepo(stub).Get(to.BillID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 保存票据流转信息
+	err = recordBillTransfer(stub, to.transferInfo())
+	if err != nil {
+		return nil, err
+	}
+
+	// 更新票据所有者
+	bill.Owner = to.NewOwner
+	bill.OwnerName = to.NewOwnerName
+	bill.Transferred = true
+	err = setBillStateThenPut(stub, bill, BillTransferring, Endorsed)
+	if err != nil {
+		return nil, err
+	}
+
+	to.State = OfferAccepted
+	to.CloseDate = now
+	err = NewTransferOfferRepo(stub).Put(to)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"bill": bill, "offer": to}, nil
+}
+
+//declineBillTransfer 接收方拒绝接收流转的票据，票据退回原持有人
+//  args: 0 - Bill_No ; 1 - New Owner Name
+func (sfb *SupplyFinance) declineBillTransfer(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	to, err := getPendingOffer(stub, args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if to.NewOwnerName != args.String(1) {
+		return nil, errForbidden("Chaincode Invoke declineBillTransfer failed: the receiver is not same with the offer's")
+	}
+
+	bill, err := closeTransferOffer(stub, to, OfferDeclined)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"bill": bill, "offer": to}, nil
+}
+
+//cancelBillTransfer 持票人撤回未接收的流转，过期的要约也通过撤回释放票据
+//  args: 0 - Bill_No ; 1 - Owner Name
+func (sfb *SupplyFinance) cancelBillTransfer(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	to, err := getPendingOffer(stub, args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if to.OldOwnerName != args.String(1) {
+		return nil, errForbidden("Chaincode Invoke cancelBillTransfer failed: owner is not same with current owner")
+	}
+
+	bill, err := closeTransferOffer(stub, to, OfferCancelled)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"bill": bill, "offer": to}, nil
+}
+
+//legacyBillTransfer 旧版本保存的票据流转信息，没有json标签，流转记录以流转次数为key
+type legacyBillTransfer struct {
+	BillID    string                  `json:"BillID"`
+	Count     int                     `json:"Count"`
+	Transfers map[int]TransferInfoArg `json:"Transfers"`
+}
+
+//UnmarshalJSON 兼容旧版本的流转信息，转换为按顺序排列的流转链，旧记录没有交易ID和时间
+func (bt *BillTransfer) UnmarshalJSON(data []byte) error {
+	type plain BillTransfer
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+
+	if p.BillID == "" {
+		var legacy legacyBillTransfer
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return err
+		}
+
+		p.BillID = legacy.BillID
+		p.Hops = make([]TransferHop, 0, len(legacy.Transfers))
+		for i := 1; i <= legacy.Count; i++ {
+			ti, exist := legacy.Transfers[i]
+			if !exist {
+				continue
+			}
+
+			p.Hops = append(p.Hops, TransferHop{
+				Seq:          len(p.Hops) + 1,
+				BillID:       legacy.BillID,
+				OldOwner:     ti.OldOwner,
+				OldOwnerName: ti.OldOwnerName,
+				NewOwner:     ti.NewOwner,
+				NewOwnerName: ti.NewOwnerName,
+			})
+		}
+	}
+
+	if p.Hops == nil {
+		p.Hops = []TransferHop{}
+	}
+
+	*bt = BillTransfer(p)
+	return nil
+}
+
+//BillTransferChain 票据完整的背书流转链，子票据包含拆分前原始票据及各级父票据的流转
+type BillTransferChain struct {
+	BillID   string        `json:"bill_id"`   //查询的票据编号
+	BillPath []string      `json:"bill_path"` //从原始票据到该票据的票据编号
+	Hops     []TransferHop `json:"hops"`      //按时间顺序排列的流转记录
+}
+
+// 取得从原始票据到该票据的票据链，原始票据在前
+func billAncestors(repo BillRepo, bill *Bill) ([]*Bill, error) {
+	path := []*Bill{bill}
+	visited := map[string]bool{bill.BillID: true}
+
+	for bill.ParentID != "" {
+		// 原始票据的ParentID为合同号
+		parent, err := repo.Find(bill.ParentID)
+		if err != nil {
+			return nil, err
+		} else if parent == nil {
+			break
+		}
+
+		if visited[parent.BillID] {
+			return nil, errCorrupt(billTable.Name, parent.BillID, "circular parent of bill")
+		}
+		visited[parent.BillID] = true
+
+		path = append([]*Bill{parent}, path...)
+		bill = parent
+	}
+
+	return path, nil
+}
+
+//queryBillTransferChain 按顺序查询票据的背书流转链，子票据包含拆分前父票据的流转
+//  args: 0 - Bill_No
+func (sfb *SupplyFinance) queryBillTransferChain(stub shim.ChaincodeStubInterface, args Args) (interface{}, error) {
+	repo := NewBillRepo(stub)
+	bill, err := repo.Get(args.String(0))
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := billAncestors(repo, bill)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := BillTransferChain{BillID: bill.BillID, BillPath: make([]string, 0, len(path)), Hops: []TransferHop{}}
+	btRepo := NewBillTransferRepo(stub)
+	for _, b := range path {
+		bt, err := btRepo.Find(b.BillID)
+		if err != nil {
+			return nil, err
+		}
+
+		chain.BillPath = append(chain.BillPath, b.BillID)
+		chain.Hops = append(chain.Hops, bt.Hops...)
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// 流转参数，ttl为0时使用默认有效期
+func transferArg(billID, from, to string, ttl int64) string {
+	return fmt.Sprintf(`{"ti_bill_id":"%s","old_owner_name":"%sn","new_owner":"%s","new_owner_name":"%sn","offer_ttl":%d}`, billID, from, to, to, ttl)
+}
+
+// 流转操作的返回结果
+type transferResult struct {
+	Bill  Bill          `json:"bill"`
+	Offer TransferOffer `json:"offer"`
+}
+
+func TestTransferNeedsAcceptance(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.issueEndorsedBill("b1", "drawee", "owner", 1000)
+
+	var res transferResult
+	decodeData(t, mustOK(t, s.invoke("transferBill", transferArg("b1", "owner", "payee", 0))), &res)
+	if res.Bill.State != BillTransferring || res.Bill.Owner != "owner" || res.Offer.State != OfferPending {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if res.Offer.ExpireDate != s.now+DefaultTransferOfferTTL {
+		t.Fatalf("unexpected expire date: %d", res.Offer.ExpireDate)
+	}
+
+	// 待接收期间票据不能质押、作废或再次流转
+	mustFail(t, s.applyLoan("l1", "b1", "owner", 800, ""), ErrWrongState)
+	mustFail(t, s.invoke("abolishBill", "b1", "ownern"), ErrWrongState)
+	mustFail(t, s.invoke("transferBill", transferArg("b1", "owner", "other", 0)), ErrWrongState)
+
+	mustFail(t, s.invoke("acceptBillTransfer", "b1", "othern"), ErrForbidden)
+
+	var accepted transferResult
+	decodeData(t, mustOK(t, s.invoke("acceptBillTransfer", "b1", "payeen")), &accepted)
+	if accepted.Bill.State != Endorsed || accepted.Bill.Owner != "payee" || !accepted.Bill.Transferred || accepted.Offer.State != OfferAccepted {
+		t.Fatalf("unexpected result: %+v", accepted)
+	}
+
+	mustFail(t, s.invoke("acceptBillTransfer", "b1", "payeen"), ErrWrongState)
+}
+
+func TestTransferOfferDeclineAndExpiry(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.issueEndorsedBill("b1", "drawee", "owner", 1000)
+
+	// 接收方拒绝后票据退回原持有人
+	mustOK(t, s.invoke("transferBill", transferArg("b1", "owner", "payee", 0)))
+	var res transferResult
+	decodeData(t, mustOK(t, s.invoke("declineBillTransfer", "b1", "payeen")), &res)
+	if res.Bill.State != Endorsed || res.Bill.Owner != "owner" || res.Offer.State != OfferDeclined {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	// 过期的要约不能接收，持票人撤回后记录为过期
+	mustOK(t, s.invoke("transferBill", transferArg("b1", "owner", "payee", testDay)))
+	s.now += testDay + 1
+	mustFail(t, s.invoke("acceptBillTransfer", "b1", "payeen"), ErrExpired)
+	mustFail(t, s.invoke("cancelBillTransfer", "b1", "payeen"), ErrForbidden)
+
+	var cancelled transferResult
+	decodeData(t, mustOK(t, s.invoke("cancelBillTransfer", "b1", "ownern")), &cancelled)
+	if cancelled.Bill.State != Endorsed || cancelled.Bill.Owner != "owner" || cancelled.Offer.State != OfferExpired {
+		t.Fatalf("unexpected result: %+v", cancelled)
+	}
+}
+
+func TestBillTransferChain(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	s.issueEndorsedBill("b1", "drawee", "owner", 1000)
+
+	mustOK(t, s.invoke("transferBill", transferArg("b1", "owner", "payee", 0)))
+	mustOK(t, s.invoke("acceptBillTransfer", "b1", "payeen"))
+
+	// 拆分后的子票据继承父票据的流转链
+	split := fmt.Sprintf(`{"bill_id":"b1","owner_name":"payeen","split_date":%d,"child_bills":[{"bill_id":"b11","owner":"payee","owner_name":"payeen","amount":600},{"bill_id":"b12","owner":"payee","owner_name":"payeen","amount":400}]}`, s.now)
+	mustOK(t, s.invoke("splitBill", split))
+	s.now += 1000
+	mustOK(t, s.invoke("transferBill", transferArg("b11", "payee", "buyer", 0)))
+	mustOK(t, s.invoke("acceptBillTransfer", "b11", "buyern"))
+
+	var chain BillTransferChain
+	decodeData(t, mustOK(t, s.invoke("queryBillTransferChain", "b11")), &chain)
+	if fmt.Sprint(chain.BillPath) != "[b1 b11]" || len(chain.Hops) != 2 {
+		t.Fatalf("unexpected chain: %+v", chain)
+	}
+	first, second := chain.Hops[0], chain.Hops[1]
+	if first.BillID != "b1" || first.NewOwner != "payee" || second.BillID != "b11" || second.OldOwner != "payee" || second.NewOwner != "buyer" {
+		t.Fatalf("unexpected hops: %+v", chain.Hops)
+	}
+	if first.TxID == "" || second.TxID == "" || second.Timestamp != first.Timestamp+1000 {
+		t.Fatalf("hops should record tx id and time: %+v", chain.Hops)
+	}
+}
+
+func TestLegacyBillTransfer(t *testing.T) {
+	s := newTestStub(t)
+
+	// 旧版本的流转信息没有json标签，以流转次数为key
+	legacy := map[string]interface{}{
+		"BillID": "b1",
+		"Count":  2,
+		"Transfers": map[string]TransferInfoArg{
+			"2": {BillID: "b1", OldOwner: "payee", NewOwner: "buyer"},
+			"1": {BillID: "b1", OldOwner: "owner", NewOwner: "payee"},
+		},
+	}
+	s.putRaw(billTransferTable, "b1", legacy)
+
+	var bt BillTransfer
+	s.getRecord(billTransferTable, "b1", &bt)
+	if bt.BillID != "b1" || len(bt.Hops) != 2 || bt.Hops[0].NewOwner != "payee" || bt.Hops[1].Seq != 2 || bt.Hops[1].NewOwner != "buyer" {
+		t.Fatalf("unexpected transfer: %+v", bt)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 字段校验规则写在结构体的sf标签中，多个规则用逗号分隔：
+//  required      - 不能为零值(空串、0)
+//	readonly      - 由链码维护，客户端不能传入非零值
+//	gt=N / gte=N  - 数值大于/大于等于N
+//	lte=N         - 数值小于等于N
+//	gtfield=F     - 数值大于同一结构体中字段F的值
+//	nefield=F     - 不能与同一结构体中字段F的值相同
+//	excludes=S    - 字符串不能包含S，如拼接记录ID的分隔符
+const validateTag = "sf"
+
+//Violation 一条校验不通过的信息
+type Violation struct {
+	Field   string `json:"field"`   //JSON字段名
+	Rule    string `json:"rule"`    //不满足的规则
+	Message string `json:"message"` //描述
+}
+
+// 字段的JSON名，没有json标签时用字段名
+func jsonFieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return f.Name
+	}
+
+	return name
+}
+
+// 取数值字段的值，非数值字段返回false
+func numberValue(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+
+	return 0, false
+}
+
+func isZeroValue(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// 按一条规则校验字段，返回不通过的描述，通过时返回空串
+func checkRule(sv reflect.Value, fv reflect.Value, rule, param string) string {
+	switch rule {
+	case "required":
+		if isZeroValue(fv) {
+			return "is required"
+		}
+	case "readonly":
+		if !isZeroValue(fv) {
+			return "is maintained by chaincode and should not be supplied"
+		}
+	case "gt", "gte", "lte":
+		n, ok := numberValue(fv)
+		limit, err := strconv.ParseFloat(param, 64)
+		if !ok || err != nil {
+			return fmt.Sprintf("rule %s=%s is not applicable", rule, param)
+		}
+
+		if rule == "gt" && !(n > limit) {
+			return fmt.Sprintf("should be greater than %s", param)
+		} else if rule == "gte" && !(n >= limit) {
+			return fmt.Sprintf("should be greater than or equal to %s", param)
+		} else if rule == "lte" && !(n <= limit) {
+			return fmt.Sprintf("should be less than or equal to %s", param)
+		}
+	case "gtfield":
+		other := sv.FieldByName(param)
+		n, ok1 := numberValue(fv)
+		o, ok2 := numberValue(other)
+		if !ok1 || !ok2 {
+			return fmt.Sprintf("rule %s=%s is not applicable", rule, param)
+		}
+
+		if !(n > o) {
+			f, _ := sv.Type().FieldByName(param)
+			return fmt.Sprintf("should be greater than %s", jsonFieldName(f))
+		}
+	case "nefield":
+		other := sv.FieldByName(param)
+		if !other.IsValid() {
+			return fmt.Sprintf("rule %s=%s is not applicable", rule, param)
+		}
+
+		if !isZeroValue(fv) && reflect.DeepEqual(fv.Interface(), other.Interface()) {
+			f, _ := sv.Type().FieldByName(param)
+			return fmt.Sprintf("should not be same with %s", jsonFieldName(f))
+		}
+	case "excludes":
+		if fv.Kind() != reflect.String {
+			return fmt.Sprintf("rule %s=%s is not applicable", rule, param)
+		}
+
+		if strings.Contains(fv.String(), param) {
+			return fmt.Sprintf("should not contain %q", param)
+		}
+	default:
+		return fmt.Sprintf("unknown rule %s", rule)
+	}
+
+	return ""
+}
+
+// 按sf标签校验结构体的全部字段，收集所有不通过的规则
+func validateFields(pObj interface{}) []Violation {
+	violations := make([]Violation, 0)
+
+	sv := reflect.Indirect(reflect.ValueOf(pObj))
+	if sv.Kind() != reflect.Struct {
+		return violations
+	}
+
+	t := sv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get(validateTag)
+		if tag == "" {
+			continue
+		}
+
+		for _, r := range strings.Split(tag, ",") {
+			rule, param := r, ""
+			if idx := strings.Index(r, "="); idx >= 0 {
+				rule, param = r[:idx], r[idx+1:]
+			}
+
+			if msg := checkRule(sv, sv.Field(i), rule, param); msg != "" {
+				name := jsonFieldName(f)
+				violations = append(violations, Violation{Field: name, Rule: r, Message: name + " " + msg})
+			}
+		}
+	}
+
+	return violations
+}
+
+// 校验客户端传入的参数，不通过时返回INVALID_ARG，Details中带全部不通过的字段
+func validateArg(pObj interface{}) error {
+	violations := validateFields(pObj)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	res := newError(ErrInvalidArg, "argument validation failed: %s", violations[0].Message)
+	return res.With("violations", violations)
+}
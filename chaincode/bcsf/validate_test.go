@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIssueContractValidation(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+	arg := toJSON(t, testContract("c1", "yuan", 1000))
+
+	// 字段名拼写错误时不能当作零值
+	typo := strings.Replace(arg, `"ct_amount"`, `"ct_amout"`, 1)
+	mustFail(t, s.invoke("issueContract", typo), ErrInvalidArg)
+
+	// 一次返回全部不通过的字段
+	ct := testContract("c1", "yuan", 0)
+	ct.DueDate = ct.IssueDate
+	ct.DraweeName = ct.PyeeName
+	ct.State = Endorsed
+	ret := mustFail(t, s.invoke("issueContract", toJSON(t, ct)), ErrInvalidArg)
+
+	var violations []Violation
+	decodeData(t, chaincodeRet{Data: ret.Details["violations"]}, &violations)
+	fields := make([]string, 0, len(violations))
+	for _, v := range violations {
+		fields = append(fields, v.Field+":"+v.Rule)
+	}
+	expected := "ct_amount:gt=0 ct_due_date:gtfield=IssueDate ct_drawee_name:nefield=PyeeName ct_state:readonly"
+	if strings.Join(fields, " ") != expected {
+		t.Fatalf("unexpected violations: %v", fields)
+	}
+
+	mustOK(t, s.invoke("issueContract", arg))
+}
+
+func TestIssueBillValidation(t *testing.T) {
+	s := newTestStub(t)
+	s.as(newIdentity(t, "OwnerMSP", "owner", ""))
+
+	bill := testBill("", "drawee", "owner", -1)
+	bill.SplitCount = 1
+	ret := mustFail(t, s.invoke("issueBill", toJSON(t, bill)), ErrInvalidArg)
+	if violations, _ := ret.Details["violations"].([]interface{}); len(violations) != 3 {
+		t.Fatalf("unexpected violations: %+v", ret.Details)
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	type arg struct {
+		ID    string  `json:"id" sf:"excludes=~"`
+		Rate  float64 `json:"rate" sf:"gte=0,lte=100"`
+		Count int     `sf:"unknown"`
+	}
+
+	violations := validateFields(&arg{ID: "a~b", Rate: 101})
+	if len(violations) != 3 {
+		t.Fatalf("unexpected violations: %+v", violations)
+	}
+	if violations[0].Field != "id" || violations[1].Rule != "lte=100" || violations[2].Field != "Count" {
+		t.Fatalf("unexpected violations: %+v", violations)
+	}
+
+	if violations = validateFields(&arg{ID: "ab", Rate: 0}); len(violations) != 1 {
+		t.Fatalf("unexpected violations: %+v", violations)
+	}
+}